@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//An archive is a single file that certificates only get appended to. It starts with archiveMagic,
+//then every entry is written as its length followed by the bbchallenge id + 1 (0 for no id)
+//and the certificate in the binary format. The index is rebuilt from the entries when the archive is opened.
+const archiveMagic = "\x00BNA"
+
+type archive struct {
+	file     *os.File
+	writable bool
+	size     int64
+	offsets  []int64
+	byTm     map[string][]int64
+	byId     map[int][]int64
+}
+
+type archiveEntry struct {
+	id     int //-1 if the id is unknown
+	record []byte
+}
+
+//normalizedTM is the key of a machine in the archive, so isomorphic machines share their certificates
+func normalizedTM(tm turingMachine) string {
+	normalized, _ := tm.normalize(true)
+	return normalized.String()
+}
+
+//openArchive opens the archive for reading, or with writable for adding to it, which creates it if it
+//doesn't exist yet. An entry that was cut off at the end of the file by an interrupted add is removed
+//when the archive is writable and left out otherwise. Any other broken entry makes the archive damaged.
+func openArchive(path string, writable bool) (*archive, error) {
+	flags := os.O_RDONLY
+	if writable {
+		flags = os.O_RDWR | os.O_CREATE
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &archive{
+		file:     file,
+		writable: writable,
+		byTm:     map[string][]int64{},
+		byId:     map[int][]int64{},
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 && writable {
+		if _, err := file.WriteAt([]byte(archiveMagic), 0); err != nil {
+			file.Close()
+			return nil, err
+		}
+		a.size = int64(len(archiveMagic))
+		return a, nil
+	}
+	head := make([]byte, len(archiveMagic))
+	if _, err := file.ReadAt(head, 0); err != nil || string(head) != archiveMagic {
+		file.Close()
+		return nil, errors.New("Not a certificate archive: " + path)
+	}
+
+	a.size = int64(len(archiveMagic))
+	input := bufio.NewReader(io.NewSectionReader(file, a.size, info.Size()-a.size))
+	for {
+		entry, length, err := readEntry(input)
+		if err == io.EOF {
+			break
+		}
+		//the section ends with the file, so only the last entry can run out of bytes
+		if err == io.ErrUnexpectedEOF {
+			if !writable {
+				fmt.Fprintf(os.Stderr, "Leaving out the incomplete entry at the end of %s\n", path)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "Removing the incomplete entry at the end of %s\n", path)
+			if err := file.Truncate(a.size); err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		if err == nil {
+			err = a.index(a.size, entry)
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Damaged archive %s, entry at offset %d: %w", path, a.size, err)
+		}
+		a.size += length
+	}
+	return a, nil
+}
+
+func (a *archive) close() error {
+	if !a.writable {
+		return a.file.Close()
+	}
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+//readEntry returns the entry and the number of bytes it takes in the archive
+func readEntry(input *bufio.Reader) (archiveEntry, int64, error) {
+	length, err := binary.ReadUvarint(input)
+	if err != nil {
+		//io.EOF before the first byte, io.ErrUnexpectedEOF inside the length, or an overflow
+		return archiveEntry{}, 0, err
+	}
+	if length > maxRecordLength {
+		return archiveEntry{}, 0, fmt.Errorf("Entry is too long with %d bytes", length)
+	}
+	data, err := readRecord(input, length)
+	if err != nil {
+		return archiveEntry{}, 0, io.ErrUnexpectedEOF
+	}
+	entry, err := parseEntry(data)
+	if err != nil {
+		return archiveEntry{}, 0, err
+	}
+	var lengthBytes [binary.MaxVarintLen64]byte
+	return entry, int64(binary.PutUvarint(lengthBytes[:], length)) + int64(length), nil
+}
+
+func parseEntry(data []byte) (archiveEntry, error) {
+	id, n := binary.Uvarint(data)
+	if n <= 0 {
+		return archiveEntry{}, errors.New("Unable to read the id of an entry")
+	}
+	return archiveEntry{int(id) - 1, data[n:]}, nil
+}
+
+//recordTm reads the tm at the start of a certificate in the binary format without decoding the rest
+func recordTm(record []byte) (string, error) {
+	r := bytes.NewReader(record)
+	if _, err := r.ReadByte(); err != nil {
+		return "", err
+	}
+	if _, err := binary.ReadUvarint(r); err != nil {
+		return "", err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil || length > uint64(r.Len()) {
+		return "", errors.New("Unable to read the tm of a certificate")
+	}
+	text := make([]byte, length)
+	_, _ = r.Read(text)
+	tm := turingMachine{}
+	if err := tm.UnmarshalText(text); err != nil {
+		return "", err
+	}
+	return normalizedTM(tm), nil
+}
+
+func (a *archive) index(offset int64, entry archiveEntry) error {
+	tm, err := recordTm(entry.record)
+	if err != nil {
+		return err
+	}
+	a.offsets = append(a.offsets, offset)
+	a.byTm[tm] = append(a.byTm[tm], offset)
+	if entry.id >= 0 {
+		a.byId[entry.id] = append(a.byId[entry.id], offset)
+	}
+	return nil
+}
+
+func (a *archive) read(offset int64) (archiveEntry, error) {
+	entry, _, err := readEntry(bufio.NewReader(io.NewSectionReader(a.file, offset, a.size-offset)))
+	return entry, err
+}
+
+//add appends a certificate in the binary format unless the archive already contains it
+func (a *archive) add(id int, record []byte) (bool, error) {
+	tm, err := recordTm(record)
+	if err != nil {
+		return false, err
+	}
+	for _, offset := range a.byTm[tm] {
+		entry, err := a.read(offset)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(entry.record, record) && (entry.id == id || id < 0) {
+			return false, nil
+		}
+	}
+	entry := &certEncoder{}
+	entry.uint(id + 1)
+	entry.buf.Write(record)
+	data := &certEncoder{}
+	data.uint(entry.buf.Len())
+	data.buf.Write(entry.buf.Bytes())
+	if _, err := a.file.WriteAt(data.buf.Bytes(), a.size); err != nil {
+		return false, err
+	}
+	if err := a.index(a.size, archiveEntry{id, record}); err != nil {
+		return false, err
+	}
+	a.size += int64(data.buf.Len())
+	return true, nil
+}
+
+//lookup finds the certificates for a key, which is either a bbchallenge id or a tm in standard text format
+func (a *archive) lookup(key string) ([]archiveEntry, error) {
+	var offsets []int64
+	if id, err := strconv.Atoi(key); err == nil {
+		offsets = a.byId[id]
+	} else {
+		tm := turingMachine{}
+		if err := tm.UnmarshalText([]byte(key)); err != nil {
+			return nil, err
+		}
+		offsets = a.byTm[normalizedTM(tm)]
+	}
+	entries := []archiveEntry{}
+	for _, offset := range offsets {
+		entry, err := a.read(offset)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+//the text format of an entry is the certificate in JSON, with the bbchallenge id in front if it is known
+func (entry archiveEntry) text() (string, error) {
+	cert, err := decodeCert(entry.record)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(cert)
+	if err != nil {
+		return "", err
+	}
+	if entry.id < 0 {
+		return string(b), nil
+	}
+	return strconv.Itoa(entry.id) + " " + string(b), nil
+}
+
+//parseCertLine reads a certificate in JSON with an optional bbchallenge id in front
+//and returns it in the binary format
+func parseCertLine(text string) (int, []byte, error) {
+	id := -1
+	if i := strings.IndexByte(text, ' '); i > 0 && text[0] >= '0' && text[0] <= '9' {
+		var err error
+		id, err = strconv.Atoi(text[:i])
+		if err != nil {
+			return 0, nil, err
+		}
+		text = strings.TrimSpace(text[i+1:])
+	}
+	//full certificates are recognized by their format or, for older ones, by their rules
+	probe := struct {
+		Format string
+		Rules  json.RawMessage
+	}{}
+	if err := json.Unmarshal([]byte(text), &probe); err != nil {
+		return 0, nil, err
+	}
+	if probe.Format == fullFormat || probe.Format == "" && probe.Rules != nil {
+		cert := fullCert{}
+		if err := json.Unmarshal([]byte(text), &cert); err != nil {
+			return 0, nil, err
+		}
+		record, err := encodeFull(cert)
+		return id, record, err
+	}
+	cert := shortCert{}
+	if err := json.Unmarshal([]byte(text), &cert); err != nil {
+		return 0, nil, err
+	}
+	record, err := encodeShort(cert)
+	return id, record, err
+}
+
+//addToArchive adds the certificates from the output of the decider or an export, or from a binary file
+func addToArchive(a *archive, input *bufio.Reader) {
+	added, total := 0, 0
+	addRecord := func(id int, record []byte) {
+		total++
+		ok, err := a.add(id, record)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if ok {
+			added++
+		}
+	}
+	if isBinary(input) {
+		cr, err := newCertReader(input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		for {
+			cert, err := cr.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				if _, ok := err.(decodeError); ok {
+					continue
+				}
+				break
+			}
+			var record []byte
+			switch cert := cert.(type) {
+			case fullCert:
+				record, err = encodeFull(cert)
+			case shortCert:
+				record, err = encodeShort(cert)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			addRecord(-1, record)
+		}
+	} else {
+		var readerErr error
+		for readerErr == nil {
+			var text string
+			text, readerErr = input.ReadString('\n')
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			id, record, err := parseCertLine(text)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
+				continue
+			}
+			addRecord(id, record)
+		}
+		if readerErr != io.EOF {
+			fmt.Fprintln(os.Stderr, readerErr)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Added %d of %d certificates\n", added, total)
+}
+
+//exportArchive prints the certificates of the machines in keys, or all of them if keys is nil
+func exportArchive(a *archive, output io.Writer, keys []string) {
+	if keys == nil {
+		for _, offset := range a.offsets {
+			entry, err := a.read(offset)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			printEntry(output, entry, fmt.Sprintf("the entry at offset %d", offset))
+		}
+		return
+	}
+	for _, key := range keys {
+		entries, err := a.lookup(key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to look up %s\n%s\n", key, err)
+			continue
+		}
+		for _, entry := range entries {
+			printEntry(output, entry, "an entry for "+key)
+		}
+	}
+}
+
+//printEntry prints the text format of an entry, or why it can't be decoded
+func printEntry(output io.Writer, entry archiveEntry, name string) {
+	text, err := entry.text()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to decode %s\n%s\n", name, err)
+		return
+	}
+	fmt.Fprintln(output, text)
+}
+
+//verifyArchive checks every certificate and prints those that don't verify
+func verifyArchive(a *archive, output io.Writer, workTokens chan struct{}) {
+	var mutex sync.Mutex
+	valid := 0
+	for _, offset := range a.offsets {
+		offset := offset
+		entry, err := a.read(offset)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		_ = <-workTokens
+		go func() {
+			defer func() {
+				workTokens <- struct{}{}
+				if err := recover(); err != nil {
+					fmt.Fprintf(os.Stderr, "Panic at the entry at offset %d\n%s\n", offset, err)
+				}
+			}()
+			cert, err := decodeCert(entry.record)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to decode the entry at offset %d\n%s\n", offset, err)
+				return
+			}
+			ok := false
+			switch cert := cert.(type) {
+			case fullCert:
+				ok = verifyBouncer(cert)
+			case shortCert:
+				full, err := expandShortCert(cert)
+				ok = err == nil && verifyBouncer(full)
+			}
+			if !ok {
+				printEntry(output, entry, fmt.Sprintf("the entry at offset %d", offset))
+				return
+			}
+			mutex.Lock()
+			valid++
+			mutex.Unlock()
+		}()
+	}
+	for i := 0; i < cap(workTokens); i++ {
+		_ = <-workTokens
+	}
+	for i := 0; i < cap(workTokens); i++ {
+		workTokens <- struct{}{}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d certificates are valid\n", valid, len(a.offsets))
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+)
+
+//A binary certificate file starts with binaryMagic, which can't be the start of a JSON certificate.
+//Then every certificate is written as its length followed by
+//	kind: 'F' or 'S', version
+//	tm as text, flags: 1 for mirror, 2 if a mapping follows, the mapping
+//	the table of words: the count, then the length and the packed symbols of every word
+//	Start, then CycleSteps or the rules
+//	the variants with their Start and CycleSteps or rules
+//Counts and word indices are uvarints, all other numbers are varints.
+//Each word is stored once in the table and referenced by its index everywhere else.
+const binaryMagic = "\x00BNC"
+
+//a limit for corrupted lengths, real certificates are much shorter
+const maxRecordLength = 1 << 30
+
+//readRecord reads a certificate of the given length. The record only grows with the bytes
+//that are actually read, so a corrupted length fails at the end of the input instead of allocating it.
+func readRecord(r io.Reader, length uint64) ([]byte, error) {
+	record := &bytes.Buffer{}
+	if _, err := io.CopyN(record, r, int64(length)); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return record.Bytes(), nil
+}
+
+const fullKind = 'F'
+const shortKind = 'S'
+
+type certWriter struct {
+	w       *bufio.Writer
+	started bool
+}
+
+func newCertWriter(w io.Writer) *certWriter {
+	return &certWriter{w: bufio.NewWriter(w)}
+}
+
+func (cw *certWriter) writeFull(cert fullCert) error {
+	record, err := encodeFull(cert)
+	if err != nil {
+		return err
+	}
+	return cw.write(record)
+}
+
+func (cw *certWriter) writeShort(cert shortCert) error {
+	record, err := encodeShort(cert)
+	if err != nil {
+		return err
+	}
+	return cw.write(record)
+}
+
+func (cw *certWriter) write(record []byte) error {
+	if !cw.started {
+		if _, err := cw.w.WriteString(binaryMagic); err != nil {
+			return err
+		}
+		cw.started = true
+	}
+	length := &certEncoder{}
+	length.uint(len(record))
+	if _, err := cw.w.Write(length.buf.Bytes()); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(record)
+	return err
+}
+
+func encodeFull(cert fullCert) ([]byte, error) {
+	return encodeRecord(fullKind, cert.Tm, cert.Mirror, cert.Mapping, func(e *certEncoder) {
+		e.start(cert.Start)
+		e.rules(cert.Rules)
+		e.uint(len(cert.Variants))
+		for _, variant := range cert.Variants {
+			e.start(variant.Start)
+			e.rules(variant.Rules)
+		}
+	})
+}
+
+func encodeShort(cert shortCert) ([]byte, error) {
+	return encodeRecord(shortKind, cert.Tm, cert.Mirror, cert.Mapping, func(e *certEncoder) {
+		e.start(cert.Start)
+		e.int(cert.CycleSteps)
+		e.uint(len(cert.Variants))
+		for _, variant := range cert.Variants {
+			e.start(variant.Start)
+			e.int(variant.CycleSteps)
+		}
+	})
+}
+
+//the body is encoded first, so the word table is complete before it gets written.
+//Symbols are packed into the bits the tm needs, so one that isn't a symbol of the tm can't be encoded.
+func encodeRecord(kind byte, tm turingMachine, mirror bool, mapping *tmMapping, encodeBody func(e *certEncoder)) ([]byte, error) {
+	body := &certEncoder{wordIndex: map[string]int{}}
+	encodeBody(body)
+
+	record := &certEncoder{}
+	record.buf.WriteByte(kind)
+	record.uint(certVersion)
+	record.text(tm.String())
+	flags := byte(0)
+	if mirror {
+		flags |= 1
+	}
+	if mapping != nil {
+		flags |= 2
+	}
+	record.buf.WriteByte(flags)
+	if mapping != nil {
+		record.mapping(*mapping)
+	}
+	record.uint(len(body.words))
+	symbolBits := bitsPerSymbol(tm)
+	for _, w := range body.words {
+		for _, sy := range w {
+			if sy < 0 || int(sy) >= tm.numSymbols {
+				return nil, fmt.Errorf("Unable to encode symbol %d, the tm has %d symbols", sy, tm.numSymbols)
+			}
+		}
+		record.uint(len(w))
+		record.buf.Write(packSymbols(w, symbolBits))
+	}
+	record.buf.Write(body.buf.Bytes())
+	return record.buf.Bytes(), nil
+}
+
+func (cw *certWriter) flush() error {
+	return cw.w.Flush()
+}
+
+type certEncoder struct {
+	buf       bytes.Buffer
+	words     []word
+	wordIndex map[string]int
+}
+
+func (e *certEncoder) uint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (e *certEncoder) int(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], int64(n))])
+}
+
+func (e *certEncoder) bool(b bool) {
+	if b {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *certEncoder) text(s string) {
+	e.uint(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *certEncoder) word(w word) {
+	key, _ := w.MarshalText()
+	i, ok := e.wordIndex[string(key)]
+	if !ok {
+		i = len(e.words)
+		e.wordIndex[string(key)] = i
+		e.words = append(e.words, w)
+	}
+	e.uint(i)
+}
+
+//the lengths of the lists are given by the tm
+func (e *certEncoder) mapping(m tmMapping) {
+	for _, state := range m.States {
+		e.uint(int(state))
+	}
+	for _, sy := range m.Symbols {
+		e.uint(int(sy))
+	}
+	e.bool(m.Mirror)
+}
+
+//nil and empty lists are both written as 0, a nil list is read back
+func (e *certEncoder) ints(list []int) {
+	e.uint(len(list))
+	for _, n := range list {
+		e.int(n)
+	}
+}
+
+func (e *certEncoder) start(start initialConditions) {
+	e.int(start.Steps)
+	e.uint(len(start.Words))
+	for _, w := range start.Words {
+		e.word(w)
+	}
+	e.uint(int(start.State))
+	e.word(start.Buffer)
+	e.int(start.Pos)
+	e.bool(bool(start.Dir))
+	e.ints(start.Multipliers)
+	e.ints(start.Offsets)
+}
+
+func (e *certEncoder) rules(rules []transitionRule) {
+	e.uint(len(rules))
+	for _, rule := range rules {
+		//the three flags of a rule share one byte
+		flags := byte(0)
+		if rule.StartDir == L {
+			flags |= 1
+		}
+		if rule.EndDir == L {
+			flags |= 2
+		}
+		if rule.Growing {
+			flags |= 4
+		}
+		e.buf.WriteByte(flags)
+		e.word(rule.StartWord)
+		e.uint(int(rule.StartState))
+		e.word(rule.StartBuffer)
+		e.int(rule.Steps)
+		e.word(rule.EndWord)
+		e.uint(int(rule.EndState))
+		e.word(rule.EndBuffer)
+		e.word(rule.Stub)
+	}
+}
+
+func bitsPerSymbol(tm turingMachine) int {
+	if tm.numSymbols <= 2 {
+		return 1
+	}
+	return bits.Len(uint(tm.numSymbols - 1))
+}
+
+func packSymbols(w word, symbolBits int) []byte {
+	packed := make([]byte, (len(w)*symbolBits+7)/8)
+	for i, sy := range w {
+		for b := 0; b < symbolBits; b++ {
+			if sy&(1<<b) != 0 {
+				bit := i*symbolBits + b
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return packed
+}
+
+func unpackSymbols(packed []byte, length int, symbolBits int) word {
+	w := make(word, length)
+	for i := range w {
+		for b := 0; b < symbolBits; b++ {
+			bit := i*symbolBits + b
+			if packed[bit/8]&(1<<(bit%8)) != 0 {
+				w[i] |= 1 << b
+			}
+		}
+	}
+	return w
+}
+
+//certReader streams the certificates of a binary file
+type certReader struct {
+	r     *bufio.Reader
+	count int
+}
+
+//isBinary tells whether the input is a binary certificate file without consuming anything
+func isBinary(input *bufio.Reader) bool {
+	head, _ := input.Peek(len(binaryMagic))
+	return string(head) == binaryMagic
+}
+
+func newCertReader(input *bufio.Reader) (*certReader, error) {
+	head := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(input, head); err != nil || string(head) != binaryMagic {
+		return nil, errors.New("Not a binary certificate file")
+	}
+	return &certReader{r: input}, nil
+}
+
+//a certificate that can't be decoded, unlike other errors the reader can continue after it
+type decodeError struct {
+	index int
+	err   error
+}
+
+func (err decodeError) Error() string {
+	return fmt.Sprintf("Unable to decode certificate %d: %s", err.index, err.err)
+}
+
+//next returns the next certificate as fullCert or shortCert and io.EOF at the end of the file
+func (cr *certReader) next() (interface{}, error) {
+	length, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("Certificate %d is cut off", cr.count)
+		}
+		return nil, err
+	}
+	if length > maxRecordLength {
+		return nil, fmt.Errorf("Certificate %d is too long with %d bytes", cr.count, length)
+	}
+	record, err := readRecord(cr.r, length)
+	if err != nil {
+		return nil, fmt.Errorf("Certificate %d is cut off", cr.count)
+	}
+	cr.count++
+	cert, err := decodeCert(record)
+	if err != nil {
+		return nil, decodeError{cr.count - 1, err}
+	}
+	return cert, nil
+}
+
+type certDecoder struct {
+	r     *bytes.Reader
+	words []word
+}
+
+func decodeCert(record []byte) (cert interface{}, err error) {
+	d := &certDecoder{r: bytes.NewReader(record)}
+	//reading past the end or out of range indices end the decoding early
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			cert, err = nil, fmt.Errorf("%v", recovered)
+		}
+	}()
+	kind := d.byte()
+	if version := d.uint(); version > certVersion {
+		return nil, fmt.Errorf("Unknown certificate version %d, versions up to %d are supported", version, certVersion)
+	}
+	tm := turingMachine{}
+	if err := tm.UnmarshalText([]byte(d.text())); err != nil {
+		return nil, err
+	}
+	flags := d.byte()
+	mirror := flags&1 != 0
+	var mapping *tmMapping
+	if flags&2 != 0 {
+		mapping = &tmMapping{
+			States:  make([]tmState, tm.numStates),
+			Symbols: make([]baseSymbol, tm.numSymbols),
+		}
+		for i := range mapping.States {
+			mapping.States[i] = tmState(d.uint())
+		}
+		for i := range mapping.Symbols {
+			mapping.Symbols[i] = baseSymbol(d.uint())
+		}
+		mapping.Mirror = d.byte() != 0
+		if !mapping.valid(tm) {
+			return nil, errors.New("Invalid mapping for " + tm.String())
+		}
+	}
+	symbolBits := bitsPerSymbol(tm)
+	d.words = make([]word, d.uint())
+	for i := range d.words {
+		length := d.uint()
+		packed := make([]byte, (length*symbolBits+7)/8)
+		if _, err := io.ReadFull(d.r, packed); err != nil {
+			return nil, err
+		}
+		d.words[i] = unpackSymbols(packed, length, symbolBits)
+	}
+
+	switch kind {
+	case fullKind:
+		full := fullCert{Tm: tm, Mirror: mirror, Start: d.start(), Rules: d.rules(), Mapping: mapping}
+		for i := d.uint(); i > 0; i-- {
+			full.Variants = append(full.Variants, fullVariant{d.start(), d.rules()})
+		}
+		cert = full
+	case shortKind:
+		short := shortCert{Tm: tm, Mirror: mirror, Start: d.start(), CycleSteps: d.int(), Mapping: mapping}
+		for i := d.uint(); i > 0; i-- {
+			short.Variants = append(short.Variants, shortVariant{d.start(), d.int()})
+		}
+		cert = short
+	default:
+		return nil, fmt.Errorf("Unknown kind of certificate %q", kind)
+	}
+	if d.r.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes left after the certificate", d.r.Len())
+	}
+	return cert, nil
+}
+
+func (d *certDecoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		panic("the certificate is too short")
+	}
+	return b
+}
+
+func (d *certDecoder) uint() int {
+	n, err := binary.ReadUvarint(d.r)
+	//no count can be bigger than the number of bits in the certificate
+	if err != nil || n > 8*uint64(d.r.Size()) {
+		panic("invalid count")
+	}
+	return int(n)
+}
+
+func (d *certDecoder) int() int {
+	n, err := binary.ReadVarint(d.r)
+	if err != nil {
+		panic("invalid number")
+	}
+	return int(n)
+}
+
+func (d *certDecoder) text() string {
+	b := make([]byte, d.uint())
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		panic("the certificate is too short")
+	}
+	return string(b)
+}
+
+func (d *certDecoder) word() word {
+	return d.words[d.uint()]
+}
+
+func (d *certDecoder) ints() []int {
+	n := d.uint()
+	if n == 0 {
+		return nil
+	}
+	list := make([]int, n)
+	for i := range list {
+		list[i] = d.int()
+	}
+	return list
+}
+
+func (d *certDecoder) start() initialConditions {
+	start := initialConditions{Steps: d.int()}
+	start.Words = make([]word, d.uint())
+	for i := range start.Words {
+		start.Words[i] = d.word()
+	}
+	start.State = tmState(d.uint())
+	start.Buffer = d.word()
+	start.Pos = d.int()
+	start.Dir = direction(d.byte() != 0)
+	start.Multipliers = d.ints()
+	start.Offsets = d.ints()
+	return start
+}
+
+func (d *certDecoder) rules() []transitionRule {
+	rules := make([]transitionRule, d.uint())
+	for i := range rules {
+		flags := d.byte()
+		rules[i] = transitionRule{
+			StartDir:    direction(flags&1 != 0),
+			EndDir:      direction(flags&2 != 0),
+			Growing:     flags&4 != 0,
+			StartWord:   d.word(),
+			StartState:  tmState(d.uint()),
+			StartBuffer: d.word(),
+			Steps:       d.int(),
+			EndWord:     d.word(),
+			EndState:    tmState(d.uint()),
+			EndBuffer:   d.word(),
+			Stub:        d.word(),
+		}
+	}
+	return rules
+}
+
+//encodeCerts converts JSON certificates of both kinds to the binary format, keeping their order
+func encodeCerts(input *bufio.Reader, output io.Writer) {
+	cw := newCertWriter(output)
+	defer func() {
+		if err := cw.flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		_, record, err := parseCertLine(text)
+		if err == nil {
+			err = cw.write(record)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to encode %s\n%s\n", text, err)
+		}
+	}
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+}
+
+//checkBinaryCerts verifies the certificates of a binary file like checkFullCerts and checkShortCerts,
+//format is the kind of certificate expected by the mode
+func checkBinaryCerts(input *bufio.Reader, output io.Writer, workTokens chan struct{}, format string, printMode int, minimize bool, original bool) {
+	cr, err := newCertReader(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	for {
+		cert, err := cr.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if _, ok := err.(decodeError); ok {
+				continue
+			}
+			return
+		}
+		index := cr.count - 1
+		_ = <-workTokens
+		go func() {
+			defer func() {
+				workTokens <- struct{}{}
+				if err := recover(); err != nil {
+					fmt.Fprintf(os.Stderr, "Panic at certificate %d\n%s\n", index, err)
+				}
+			}()
+			switch cert := cert.(type) {
+			case fullCert:
+				if format != fullFormat {
+					fmt.Fprintf(os.Stderr, "Expected a %s certificate instead of %s\n", format, fullFormat)
+					return
+				}
+				verifyCert(output, cert, printMode, minimize, original)
+			case shortCert:
+				if format != shortFormat {
+					fmt.Fprintf(os.Stderr, "Expected a %s certificate instead of %s\n", format, shortFormat)
+					return
+				}
+				full, err := expandShortCert(cert)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to expand certificate %d\n%s\n", index, err)
+					return
+				}
+				verifyCert(output, full, printMode, minimize, original)
+			}
+		}()
+	}
+}
+
+//decodeCerts prints the certificates of a binary file as JSON, one per line
+func decodeCerts(input *bufio.Reader, output io.Writer) {
+	cr, err := newCertReader(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	for {
+		cert, err := cr.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if _, ok := err.(decodeError); ok {
+				continue
+			}
+			return
+		}
+		b, err := json.Marshal(cert)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(output, string(b))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//certificate is the proof a decider found for a tm
+type certificate interface {
+	//print writes the tm or the certificate like fprintCert
+	print(w io.Writer, printMode int)
+	//withMapping records how the tm of the certificate was normalized from the machine it was found for
+	withMapping(m *tmMapping) certificate
+}
+
+//decider tries to decide a tm within a budget of steps and returns a certificate if it succeeds
+type decider interface {
+	name() string
+	decide(tm turingMachine, budget int) (certificate, bool)
+}
+
+//verifier checks the certificates of one format. It returns an error only if text can't be parsed.
+type verifier interface {
+	format() string
+	verify(text []byte) (certificate, bool, error)
+}
+
+//explainer is implemented by verifiers that can tell why a certificate doesn't verify
+type explainer interface {
+	explain(text []byte) error
+}
+
+//registeredDeciders returns every decider in the order they were added, the options are those of the bouncer decider
+func registeredDeciders(exact bool, maxSpacing int) []decider {
+	return []decider{
+		bouncerDecider{exact, maxSpacing},
+		cyclerDecider{},
+		translatedCyclerDecider{},
+	}
+}
+
+var registeredVerifiers = []verifier{
+	bouncerVerifier{},
+	shortBouncerVerifier{},
+	cyclerVerifier{},
+	translatedCyclerVerifier{},
+}
+
+func deciderNames() []string {
+	names := []string{}
+	for _, d := range registeredDeciders(false, 0) {
+		names = append(names, d.name())
+	}
+	return names
+}
+
+//newChain looks up the deciders by name, they are tried in the order of names
+func newChain(names []string, exact bool, maxSpacing int) ([]decider, error) {
+	chain := []decider{}
+	for _, name := range names {
+		found := false
+		for _, d := range registeredDeciders(exact, maxSpacing) {
+			if d.name() == name {
+				chain = append(chain, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown decider %s, expected one of %s", name, strings.Join(deciderNames(), ", "))
+		}
+	}
+	return chain, nil
+}
+
+//decideChain returns the certificate of the first decider in the chain that decides the tm
+func decideChain(chain []decider, tm turingMachine, budget int) (certificate, bool) {
+	for _, d := range chain {
+		if cert, ok := d.decide(tm, budget); ok {
+			return cert, true
+		}
+	}
+	return nil, false
+}
+
+//findVerifier picks the verifier for the format of a certificate in JSON.
+//Full certificates from before the format was recorded are recognized by their rules.
+func findVerifier(text []byte) (verifier, error) {
+	probe := struct {
+		Format string
+		Rules  json.RawMessage
+	}{}
+	if err := json.Unmarshal(text, &probe); err != nil {
+		return nil, err
+	}
+	format := probe.Format
+	if format == "" {
+		format = shortFormat
+		if probe.Rules != nil {
+			format = fullFormat
+		}
+	}
+	for _, v := range registeredVerifiers {
+		if v.format() == format {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown certificate format %s", format)
+}
+
+type bouncerDecider struct {
+	exact     bool
+	maxSpacing int
+}
+
+func (bouncerDecider) name() string { return "bouncer" }
+
+func (d bouncerDecider) decide(tm turingMachine, budget int) (certificate, bool) {
+	return scanTM(tm, budget, d.exact, d.maxSpacing)
+}
+
+type cyclerDecider struct{}
+
+func (cyclerDecider) name() string { return "cycler" }
+
+func (cyclerDecider) decide(tm turingMachine, budget int) (certificate, bool) {
+	return decideCycler(tm, budget)
+}
+
+type translatedCyclerDecider struct{}
+
+func (translatedCyclerDecider) name() string { return "tcycler" }
+
+func (translatedCyclerDecider) decide(tm turingMachine, budget int) (certificate, bool) {
+	return decideTranslatedCycler(tm, budget)
+}
+
+//bouncerVerifier returns the full certificate
+type bouncerVerifier struct{}
+
+func (bouncerVerifier) format() string { return fullFormat }
+
+func (bouncerVerifier) verify(text []byte) (certificate, bool, error) {
+	cert := fullCert{}
+	if err := json.Unmarshal(text, &cert); err != nil {
+		return nil, false, err
+	}
+	return cert, verifyBouncer(cert), nil
+}
+
+func (bouncerVerifier) explain(text []byte) error {
+	cert := fullCert{}
+	if err := json.Unmarshal(text, &cert); err != nil {
+		return err
+	}
+	return explainBouncer(cert)
+}
+
+//shortBouncerVerifier returns the expanded full certificate, a short certificate that can't be expanded is invalid
+type shortBouncerVerifier struct{}
+
+func (shortBouncerVerifier) format() string { return shortFormat }
+
+func (shortBouncerVerifier) verify(text []byte) (certificate, bool, error) {
+	cert := shortCert{}
+	if err := json.Unmarshal(text, &cert); err != nil {
+		return nil, false, err
+	}
+	full, err := expandShortCert(cert)
+	if err != nil {
+		return nil, false, nil
+	}
+	return full, verifyBouncer(full), nil
+}
+
+func (shortBouncerVerifier) explain(text []byte) error {
+	cert := shortCert{}
+	if err := json.Unmarshal(text, &cert); err != nil {
+		return err
+	}
+	full, err := expandShortCert(cert)
+	if err != nil {
+		return fmt.Errorf("Unable to derive the rules: %w", err)
+	}
+	return explainBouncer(full)
+}
+
+type cyclerVerifier struct{}
+
+func (cyclerVerifier) format() string { return cyclerFormat }
+
+func (cyclerVerifier) verify(text []byte) (certificate, bool, error) {
+	cert := cyclerCert{}
+	if err := json.Unmarshal(text, &cert); err != nil {
+		return nil, false, err
+	}
+	return cert, verifyCycler(cert), nil
+}
+
+type translatedCyclerVerifier struct{}
+
+func (translatedCyclerVerifier) format() string { return translatedCyclerFormat }
+
+func (translatedCyclerVerifier) verify(text []byte) (certificate, bool, error) {
+	cert := translatedCyclerCert{}
+	if err := json.Unmarshal(text, &cert); err != nil {
+		return nil, false, err
+	}
+	return cert, verifyTranslatedCycler(cert), nil
+}
+
+func (cert fullCert) print(w io.Writer, printMode int) {
+	fprintCert(w, cert, printMode)
+}
+
+func (cert cyclerCert) print(w io.Writer, printMode int) {
+	printOtherCert(w, cert, cert.Mapping.originalTm(cert.Tm), printMode)
+}
+
+func (cert translatedCyclerCert) print(w io.Writer, printMode int) {
+	printOtherCert(w, cert, cert.Mapping.originalTm(cert.Tm), printMode)
+}
+
+func (cert fullCert) withMapping(m *tmMapping) certificate {
+	cert.Mapping = m
+	return cert
+}
+
+func (cert cyclerCert) withMapping(m *tmMapping) certificate {
+	cert.Mapping = m
+	return cert
+}
+
+func (cert translatedCyclerCert) withMapping(m *tmMapping) certificate {
+	cert.Mapping = m
+	return cert
+}
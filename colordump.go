@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	imagecolor "image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//the size of a cell in the images of dumpColors
+const colorDumpScale = 8
+
+//colorDump is what the decider made of one record quadruple up to findRepeaters
+type colorDump struct {
+	side        direction //R for the records at the right end, which are checked on the mirrored tm
+	index       int
+	records     [4]record
+	rejected    string //why the quadruple was rejected before the tapes were colored
+	bufSize     int
+	tape1       []colorSymbol
+	tape2       []colorSymbol
+	words       []word //nil if findRepeaters failed
+	multipliers []int
+}
+
+//findColorDumps repeats the steps of findBouncer up to findRepeaters for every record quadruple it examines
+func findColorDumps(tm turingMachine, stepLimit int, maxSpacing int) []colorDump {
+	records := findRecords(tm, stepLimit)
+	dumps := []colorDump{}
+	for _, side := range []direction{L, R} {
+		sideTm := tm
+		if side == R {
+			sideTm = tm.mirror()
+		}
+		for i, quadruple := range recordQuadruples(records[side], maxSpacing) {
+			dump := colorDump{side: side, index: i + 1, records: quadruple}
+			switch {
+			case !sameStates(quadruple):
+				dump.rejected = "the records are in different states"
+			case !quadraticProgression(quadruple):
+				dump.rejected = "the steps between the records don't grow linearly"
+			default:
+				colorTape1, colorTape2, bufSize := colorRecords(sideTm, quadruple)
+				dump.bufSize = bufSize
+				dump.tape1 = colorSymbols(colorTape1)
+				dump.tape2 = colorSymbols(colorTape2)
+				dump.words, dump.multipliers = findRepeaters(colorTape1, colorTape2, bufSize)
+			}
+			dumps = append(dumps, dump)
+		}
+	}
+	return dumps
+}
+
+//colorSymbols lists the cells of a colored tape starting next to the head
+func colorSymbols(tape halfTape) []colorSymbol {
+	symbols := []colorSymbol{}
+	for sy := tape.pop(); sy != nil; sy = tape.pop() {
+		symbols = append(symbols, colorSymbol{sy.col(), sy.base()})
+	}
+	return symbols
+}
+
+func (dump colorDump) name() string {
+	side := "left"
+	if dump.side == R {
+		side = "right"
+	}
+	return fmt.Sprintf("%s%d", side, dump.index)
+}
+
+//writeText prints the colored tapes as color:symbol per cell, starting next to the head
+func (dump colorDump) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%s: records at steps %d %d %d %d in states %v %v %v %v\n", dump.name(),
+		dump.records[0].steps, dump.records[1].steps, dump.records[2].steps, dump.records[3].steps,
+		dump.records[0].state, dump.records[1].state, dump.records[2].state, dump.records[3].state)
+	if dump.rejected != "" {
+		fmt.Fprintf(w, "\trejected: %s\n", dump.rejected)
+		return
+	}
+	fmt.Fprintf(w, "\tbuffer: %d\n", dump.bufSize)
+	for i, tape := range [][]colorSymbol{dump.tape1, dump.tape2} {
+		cells := make([]string, len(tape))
+		for j, sy := range tape {
+			cells[j] = fmt.Sprintf("%d:%d", sy.color, sy.baseSymbol)
+		}
+		fmt.Fprintf(w, "\ttape%d: %s\n", i+1, strings.Join(cells, " "))
+	}
+	if dump.words == nil {
+		fmt.Fprintln(w, "\twords: none, findRepeaters couldn't split the tapes into walls and repeaters")
+		return
+	}
+	words := make([]string, len(dump.words))
+	for i, w := range dump.words {
+		text, _ := w.MarshalText()
+		words[i] = string(text)
+	}
+	fmt.Fprintf(w, "\twords: %s\n", strings.Join(words, " | "))
+	if dump.multipliers != nil {
+		fmt.Fprintf(w, "\tmultipliers: %v\n", dump.multipliers)
+	}
+}
+
+//writePNG draws both tapes with a row for the colors above a row for the symbols,
+//and under the second tape the buffer, walls and repeaters that findRepeaters split it into
+func (dump colorDump) writePNG(output io.Writer) error {
+	width := len(dump.tape2)
+	if len(dump.tape1) > width {
+		width = len(dump.tape1)
+	}
+	if width == 0 {
+		width = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width*colorDumpScale, 6*colorDumpScale))
+	fill := func(x int, y int, c imagecolor.RGBA) {
+		for py := y * colorDumpScale; py < (y+1)*colorDumpScale; py++ {
+			for px := x * colorDumpScale; px < (x+1)*colorDumpScale; px++ {
+				img.SetRGBA(px, py, c)
+			}
+		}
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < width; x++ {
+			fill(x, y, symbolColors[0])
+		}
+	}
+	for i, tape := range [][]colorSymbol{dump.tape1, dump.tape2} {
+		for x, sy := range tape {
+			fill(x, 2*i, paletteColor(int(sy.color)))
+			fill(x, 2*i+1, symbolColors[int(sy.baseSymbol)%len(symbolColors)])
+		}
+	}
+	x := 0
+	for ; x < dump.bufSize && x < width; x++ {
+		fill(x, 5, segmentColors["buffer"])
+	}
+	for i, w := range dump.words {
+		length := len(w)
+		kind := "wall"
+		if i%2 == 1 {
+			kind = "repeater"
+			if dump.multipliers != nil {
+				length *= dump.multipliers[i/2]
+			}
+		}
+		for end := x + length; x < end && x < width; x++ {
+			fill(x, 5, segmentColors[kind])
+		}
+	}
+	return png.Encode(output, img)
+}
+
+//paletteColor steps around the color wheel by the golden angle, so neighbouring color ids look different
+func paletteColor(i int) imagecolor.RGBA {
+	hue := math.Mod(float64(i)*137.508, 360) / 60
+	x := 1 - math.Abs(math.Mod(hue, 2)-1)
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	//keep the colors light enough to tell them from the symbol rows
+	shade := func(v float64) uint8 {
+		return uint8(80 + 160*v)
+	}
+	return imagecolor.RGBA{shade(r), shade(g), shade(b), 255}
+}
+
+//dumpColors writes dir/<tm>.txt with every record quadruple of the tms from the input
+//and dir/<tm>-<side><i>.png for each quadruple whose tapes were colored
+func dumpColors(input *bufio.Reader, dir string, stepLimit int, maxSpacing int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		tm := parseTM(text)
+		if tm.numStates == 0 {
+			fmt.Fprintf(os.Stderr, "Unable to parse %s\n", text)
+			continue
+		}
+		if err := writeColorDumps(tm, filepath.Join(dir, tm.String()), stepLimit, maxSpacing); err != nil {
+			return err
+		}
+	}
+	if readerErr != io.EOF {
+		return readerErr
+	}
+	return nil
+}
+
+func writeColorDumps(tm turingMachine, prefix string, stepLimit int, maxSpacing int) error {
+	file, err := os.Create(prefix + ".txt")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, dump := range findColorDumps(tm, stepLimit, maxSpacing) {
+		dump.writeText(w)
+		if dump.rejected != "" {
+			continue
+		}
+		imageFile, err := os.Create(prefix + "-" + dump.name() + ".png")
+		if err != nil {
+			file.Close()
+			return err
+		}
+		err = dump.writePNG(imageFile)
+		if closeErr := imageFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
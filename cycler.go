@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const cyclerFormat = "cycler"
+const translatedCyclerFormat = "translated-cycler"
+
+//how many cells of two records sameStart compares
+const sameStartCells = 32
+
+//how many pairs of records the translated cycler decider verifies before giving up
+const maxTranslatedCandidates = 20
+
+//cyclerCert claims that the configuration after Steps steps comes back after Period more steps
+type cyclerCert struct {
+	Format  string
+	Version int
+	Tm      turingMachine
+	Steps   int
+	Period  int
+	Mapping *tmMapping `json:",omitempty"`
+}
+
+//translatedCyclerCert claims that the records after Steps1 and Steps2 steps are in the same state
+//and the tape behind the head looks the same as far as the tm goes back in between, so the tm
+//repeats the same moves further out forever. Mirror uses the records at the right end instead of the left end.
+type translatedCyclerCert struct {
+	Format  string
+	Version int
+	Tm      turingMachine
+	Mirror  bool
+	Steps1  int
+	Steps2  int
+	Mapping *tmMapping `json:",omitempty"`
+}
+
+//machineRun simulates a tm from the blank tape with the steps of runTm, but keeps absolute positions
+//and grows the tape to the left as cheaply as to the right
+type machineRun struct {
+	tm     turingMachine
+	state  tmState
+	pos    int
+	tape   []baseSymbol
+	offset int //tape[0] is at position -offset
+	minPos int
+	maxPos int
+	steps  int
+}
+
+func newMachineRun(tm turingMachine) *machineRun {
+	return &machineRun{tm: tm, tape: []baseSymbol{0}}
+}
+
+func (run *machineRun) read(pos int) baseSymbol {
+	i := pos + run.offset
+	if i < 0 || i >= len(run.tape) {
+		return 0
+	}
+	return run.tape[i]
+}
+
+//step returns false if the tm halts
+func (run *machineRun) step() bool {
+	state, i, ok := tmStep(run.tm, run.state, run.pos+run.offset, run.tape)
+	if !ok {
+		return false
+	}
+	run.state = state
+	run.pos = i - run.offset
+	if run.pos+run.offset < 0 {
+		//grow by the length of the tape, so moving left is as cheap as moving right
+		grown := make([]baseSymbol, 2*len(run.tape))
+		copy(grown[len(run.tape):], run.tape)
+		run.offset += len(run.tape)
+		run.tape = grown
+	}
+	if run.pos+run.offset >= len(run.tape) {
+		run.tape = append(run.tape, 0)
+	}
+	if run.pos < run.minPos {
+		run.minPos = run.pos
+	}
+	if run.pos > run.maxPos {
+		run.maxPos = run.pos
+	}
+	run.steps++
+	return true
+}
+
+func (run *machineRun) run(steps int) bool {
+	for run.steps < steps {
+		if !run.step() {
+			return false
+		}
+	}
+	return true
+}
+
+//the same state, position and tape, cells that were never visited count as blank
+func (run *machineRun) sameConfig(other *machineRun) bool {
+	if run.state != other.state || run.pos != other.pos {
+		return false
+	}
+	minPos, maxPos := run.minPos, run.maxPos
+	if other.minPos < minPos {
+		minPos = other.minPos
+	}
+	if other.maxPos > maxPos {
+		maxPos = other.maxPos
+	}
+	for pos := minPos; pos <= maxPos; pos++ {
+		if run.read(pos) != other.read(pos) {
+			return false
+		}
+	}
+	return true
+}
+
+func (run *machineRun) snapshot() *machineRun {
+	copied := *run
+	copied.tape = append([]baseSymbol{}, run.tape...)
+	return &copied
+}
+
+//decideCycler looks for a configuration that repeats with Brent's algorithm:
+//the current configuration is compared to a saved one that is replaced after 1, 2, 4, ... steps
+func decideCycler(tm turingMachine, stepLimit int) (cyclerCert, bool) {
+	run := newMachineRun(tm)
+	saved := run.snapshot()
+	power := 1
+	for run.steps < stepLimit {
+		if !run.step() {
+			return cyclerCert{}, false
+		}
+		if run.sameConfig(saved) {
+			cert := cyclerCert{Tm: tm, Steps: saved.steps, Period: run.steps - saved.steps}
+			return cert, verifyCycler(cert)
+		}
+		if run.steps-saved.steps == power {
+			saved = run.snapshot()
+			power *= 2
+		}
+	}
+	return cyclerCert{}, false
+}
+
+func verifyCycler(cert cyclerCert) bool {
+	if cert.Steps < 0 || cert.Period <= 0 || cert.Steps > maxCertSteps || cert.Period > maxCertSteps-cert.Steps {
+		return false
+	}
+	run := newMachineRun(cert.Tm)
+	if !run.run(cert.Steps) {
+		return false
+	}
+	start := run.snapshot()
+	return run.run(cert.Steps+cert.Period) && run.sameConfig(start)
+}
+
+//decideTranslatedCycler compares the last record at each end of the tape with earlier records in the same state
+func decideTranslatedCycler(tm turingMachine, stepLimit int) (translatedCyclerCert, bool) {
+	records := findRecords(tm, stepLimit)
+	for _, dir := range []direction{L, R} {
+		dirRecords := records[dir]
+		if len(dirRecords) < 2 {
+			continue
+		}
+		last := dirRecords[len(dirRecords)-1]
+		candidates := 0
+		for i := len(dirRecords) - 2; i >= 0 && candidates < maxTranslatedCandidates; i-- {
+			if dirRecords[i].state != last.state || !sameStart(dirRecords[i].tape, last.tape) {
+				continue
+			}
+			candidates++
+			cert := translatedCyclerCert{Tm: tm, Mirror: dir == R, Steps1: dirRecords[i].steps, Steps2: last.steps}
+			if verifyTranslatedCycler(cert) {
+				return cert, true
+			}
+		}
+	}
+	return translatedCyclerCert{}, false
+}
+
+//a quick check whether two records can belong to a translated cycle: the half tapes start with the same cells
+func sameStart(a halfTape, b halfTape) bool {
+	cellA, cellB := a.first, b.first
+	for i := 0; i < sameStartCells && cellA != nil && cellB != nil; i++ {
+		if cellA.value.base() != cellB.value.base() {
+			return false
+		}
+		cellA, cellB = cellA.next, cellB.next
+	}
+	return true
+}
+
+func verifyTranslatedCycler(cert translatedCyclerCert) bool {
+	tm := cert.Tm
+	if cert.Mirror {
+		tm = tm.mirror()
+	}
+	if cert.Steps1 <= 0 || cert.Steps2 <= cert.Steps1 || cert.Steps2 > maxCertSteps {
+		return false
+	}
+	run := newMachineRun(tm)
+	if !run.run(cert.Steps1) {
+		return false
+	}
+	//at a record the head is on a blank cell and everything to the left of it was never visited
+	isRecord := func(run *machineRun) bool {
+		return run.pos == run.minPos && run.read(run.pos) == 0
+	}
+	if !isRecord(run) {
+		return false
+	}
+	first := run.snapshot()
+	maxPos := run.pos
+	for run.steps < cert.Steps2 {
+		if !run.step() {
+			return false
+		}
+		if run.pos > maxPos {
+			maxPos = run.pos
+		}
+	}
+	if !isRecord(run) || run.state != first.state || run.pos >= first.pos {
+		return false
+	}
+	//everything the tm read between the records has to look the same relative to the head
+	for d := 0; d <= maxPos-first.pos; d++ {
+		if first.read(first.pos+d) != run.read(run.pos+d) {
+			return false
+		}
+	}
+	return true
+}
+
+//printOtherCert prints the tm or the certificate like fprintCert, the certificates of the other deciders have no short version
+func printOtherCert(w io.Writer, cert interface{}, tm turingMachine, printMode int) {
+	switch printMode {
+	case 0:
+		fmt.Fprintln(w, tm)
+	case 1, 2:
+		b, err := json.Marshal(cert)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(w, string(b))
+	case 3, 4:
+		b, err := json.MarshalIndent(cert, "", "\t")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(w, string(b))
+	}
+}
+
+func (cert cyclerCert) MarshalJSON() ([]byte, error) {
+	type plainCert cyclerCert
+	cert.Format = cyclerFormat
+	cert.Version = certVersion
+	return json.Marshal(plainCert(cert))
+}
+
+func (cert *cyclerCert) UnmarshalJSON(text []byte) error {
+	type plainCert cyclerCert
+	if err := json.Unmarshal(text, (*plainCert)(cert)); err != nil {
+		return err
+	}
+	if cert.Mapping != nil && !cert.Mapping.valid(cert.Tm) {
+		return errors.New("Invalid mapping for " + cert.Tm.String())
+	}
+	return checkVersion(cert.Format, cert.Version, cyclerFormat)
+}
+
+func (cert translatedCyclerCert) MarshalJSON() ([]byte, error) {
+	type plainCert translatedCyclerCert
+	cert.Format = translatedCyclerFormat
+	cert.Version = certVersion
+	return json.Marshal(plainCert(cert))
+}
+
+func (cert *translatedCyclerCert) UnmarshalJSON(text []byte) error {
+	type plainCert translatedCyclerCert
+	if err := json.Unmarshal(text, (*plainCert)(cert)); err != nil {
+		return err
+	}
+	if cert.Mapping != nil && !cert.Mapping.valid(cert.Tm) {
+		return errors.New("Invalid mapping for " + cert.Tm.String())
+	}
+	return checkVersion(cert.Format, cert.Version, translatedCyclerFormat)
+}
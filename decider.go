@@ -1,28 +1,51 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 )
 
-func decideBouncers(tm turingMachine, stepLimit int, printMode int) bool {
-	return decideLeftBouncers(tm, false, stepLimit, printMode) ||
-		decideLeftBouncers(tm.mirror(), true, stepLimit, printMode)
-}
+//how many differently shaped walls the decider follows before giving up on a cycle
+const maxVariants = 4
 
-func decideLeftBouncers(tm turingMachine, mirrored bool, stepLimit int, printMode int) bool {
+//findBouncer returns a verified certificate if the tm is a bouncer.
+//The four records of a candidate are always from the same end of the tape,
+//maxSpacing limits how far apart they may be, 0 means no limit.
+func findBouncer(tm turingMachine, stepLimit int, maxSpacing int) (fullCert, bool) {
 	records := findRecords(tm, stepLimit)
-	numRecords := len(records)
-	for i := 1; i*3 < numRecords; i++ {
-		if checkRecords(tm, mirrored, [4]record{records[numRecords-1-3*i], records[numRecords-1-2*i], records[numRecords-1-i], records[numRecords-1]}, printMode) {
-			return true
+	if cert, ok := decideLeftBouncers(tm, false, records[L], maxSpacing); ok {
+		return cert, true
+	}
+	return decideLeftBouncers(tm.mirror(), true, records[R], maxSpacing)
+}
+
+//records at the right end of the tape are the left records of the mirrored tm
+func decideLeftBouncers(tm turingMachine, mirrored bool, records []record, maxSpacing int) (fullCert, bool) {
+	for _, quadruple := range recordQuadruples(records, maxSpacing) {
+		if cert, ok := checkRecords(tm, mirrored, quadruple); ok {
+			return cert, true
 		}
 	}
-	return false
+	return fullCert{}, false
+}
+
+//recordQuadruples are the last record and three earlier ones i, 2i and 3i records before it,
+//maxSpacing limits i, 0 means no limit
+func recordQuadruples(records []record, maxSpacing int) [][4]record {
+	numRecords := len(records)
+	quadruples := [][4]record{}
+	for i := 1; i*3 < numRecords && (maxSpacing <= 0 || i <= maxSpacing); i++ {
+		quadruples = append(quadruples, [4]record{records[numRecords-1-3*i], records[numRecords-1-2*i], records[numRecords-1-i], records[numRecords-1]})
+	}
+	return quadruples
 }
 
-func findRecords(tm turingMachine, stepLimit int) []record {
-	records := []record{}
+//the tape of a record is the half tape behind the head, read starting next to the head,
+//so a record at the right end looks exactly like the corresponding record of the mirrored tm
+func findRecords(tm turingMachine, stepLimit int) map[direction][]record {
+	records := map[direction][]record{L: {}, R: {}}
 	halfTapes := map[direction]*halfTape{L: {}, R: {}}
 	headCon := headConfig{}
 	for steps := 1; steps <= stepLimit; steps++ {
@@ -37,45 +60,98 @@ func findRecords(tm turingMachine, stepLimit int) []record {
 			headCon.symbol = newSy.base()
 		} else {
 			headCon.symbol = baseSymbol(0)
-			if tr.direction == L {
-				records = append(records, record{headCon.state, steps, *halfTapes[R]})
-			}
+			records[tr.direction] = append(records[tr.direction], record{headCon.state, steps, *halfTapes[!tr.direction]})
 		}
 	}
 	return records
 }
 
-func checkRecords(tm turingMachine, mirrored bool, records [4]record, printMode int) bool {
+func checkRecords(tm turingMachine, mirrored bool, records [4]record) (fullCert, bool) {
+	cert, err := explainRecords(tm, mirrored, records)
+	return cert, err == nil
+}
+
+//the stages of explainRecords in the order they run
+const (
+	stageStates = iota
+	stageProgression
+	stageRepeaters
+	stageRules
+	stageInduction
+	stageVerify
+)
+
+var stageNames = []string{"sameStates", "quadraticProgression", "findRepeaters", "findRules", "checkInduction", "verifyBouncer"}
+
+//stageError is the first stage of explainRecords that failed, bufSize is -1 if the buffer wasn't found yet
+type stageError struct {
+	stage   int
+	bufSize int
+	err     error
+}
+
+func (e *stageError) Error() string {
+	if e.bufSize < 0 {
+		return fmt.Sprintf("%s: %s", stageNames[e.stage], e.err)
+	}
+	return fmt.Sprintf("%s with buffer %d: %s", stageNames[e.stage], e.bufSize, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+//explainRecords is checkRecords with the stage that failed
+func explainRecords(tm turingMachine, mirrored bool, records [4]record) (fullCert, error) {
 	if !sameStates(records) {
-		return false
+		return fullCert{}, &stageError{stageStates, -1, fmt.Errorf("the records are in states %v %v %v %v",
+			records[0].state, records[1].state, records[2].state, records[3].state)}
 	}
 	if !quadraticProgression(records) {
-		return false
+		return fullCert{}, &stageError{stageProgression, -1, fmt.Errorf("the steps between the records are %d %d %d",
+			records[1].steps-records[0].steps, records[2].steps-records[1].steps, records[3].steps-records[2].steps)}
 	}
-	dirSequence1, historyTape1 := findContext(tm, records[0], records[1].steps-records[0].steps)
-	dirSequence2, historyTape2 := findContext(tm, records[1], records[2].steps-records[1].steps)
-
-	bufSize := findBufferSize(dirSequence1, dirSequence2)
-
-	growth := historyTape2.len - historyTape1.len
-	colorTape1 := findColors(historyTape1, growth)
-	colorTape2 := findColors(historyTape2, growth)
-
-	words := findRepeaters(colorTape1, colorTape2, bufSize)
+	colorTape1, colorTape2, bufSize := colorRecords(tm, records)
+	words, multipliers := findRepeaters(colorTape1, colorTape2, bufSize)
 	if words == nil {
-		return false
+		return fullCert{}, &stageError{stageRepeaters, bufSize, errors.New("the colored tapes don't split into walls and repeaters")}
 	}
 
-	//records[i] has buffer + repeater^(i-1) + walls
-	start := findStart(tm, records[1], bufSize, words, records[2].steps)
-	rules := findRules(tm, start, records[3].steps-records[2].steps)
-	if rules == nil {
-		return false
+	//behind the buffer, records[i] has repeater j about multipliers[j]*i times between the walls
+	//findStart replaces the first wall, so the expanded words need their own slice
+	expandedWords := expandRepeaters(words, multipliers)
+	start := findStart(tm, records[1], bufSize, words, multipliers, records[2].steps)
+	rules, variants, err := explainCycle(tm, start, records[3].steps-records[2].steps)
+	if err != nil && multipliers != nil {
+		//a single copy of a repeater can be too short to hide the turnarounds of the tm behind the buffer
+		start = findStart(tm, records[1], bufSize, expandedWords, nil, records[2].steps)
+		rules, variants, err = explainCycle(tm, start, records[3].steps-records[2].steps)
+	}
+	if err != nil {
+		err.(*stageError).bufSize = bufSize
+		return fullCert{}, err
 	}
+	cert := fullCert{Tm: tm, Start: start, Rules: rules, Variants: variants}
 	if mirrored {
-		tm = tm.mirror()
+		//turn the proof for the mirrored tm into a direct proof for the original tm
+		cert = cert.mirror()
+	}
+	if !verifyBouncer(cert) {
+		return fullCert{}, &stageError{stageVerify, bufSize, errors.New("the certificate doesn't verify")}
 	}
-	return verifyBouncer(fullCert{tm, mirrored, start, rules}, printMode)
+	return cert, nil
+}
+
+//colorRecords colors the tapes the tm leaves behind in the first two induction steps
+//and finds the size of the buffer, findRepeaters splits them into words
+func colorRecords(tm turingMachine, records [4]record) (halfTape, halfTape, int) {
+	dirSequence1, historyTape1 := findContext(tm, records[0], records[1].steps-records[0].steps)
+	dirSequence2, historyTape2 := findContext(tm, records[1], records[2].steps-records[1].steps)
+
+	bufSize := findBufferSize(dirSequence1, dirSequence2)
+
+	growth := historyTape2.len - historyTape1.len
+	return findColors(historyTape1, growth), findColors(historyTape2, growth), bufSize
 }
 
 func sameStates(records [4]record) bool {
@@ -203,50 +279,97 @@ func findColors(tape halfTape, n int) halfTape {
 	return tape
 }
 
-func findRepeaters(tape1 halfTape, tape2 halfTape, bufSize int) []word {
+//a repeater that grows by several copies of the same colored word per cycle is split into that
+//word and a multiplier, so the chain rule only has to cover a single copy
+func findRepeaters(tape1 halfTape, tape2 halfTape, bufSize int) ([]word, []int) {
 	for i := 0; i < bufSize; i++ {
 		tape1.pop()
 		tape2.pop()
 	}
 
-	words := []word{}
+	coloredWords := [][]symbol{}
 	symbol1 := tape1.pop()
 	symbol2 := tape2.pop()
-	curWord := word{}
+	curWord := []symbol{}
 	for symbol2 != nil {
-		if len(words)%2 == 0 {
+		if len(coloredWords)%2 == 0 {
 			if reflect.DeepEqual(symbol1, symbol2) {
-				curWord = append(curWord, symbol2.base())
+				curWord = append(curWord, symbol2)
 				symbol1 = tape1.pop()
 				symbol2 = tape2.pop()
 			} else {
-				words = append(words, curWord)
-				curWord = word{symbol2.base()}
+				coloredWords = append(coloredWords, curWord)
+				curWord = []symbol{symbol2}
 				symbol2 = tape2.pop()
 			}
 		} else {
 			if reflect.DeepEqual(symbol1, symbol2) {
-				words = append(words, curWord)
-				curWord = word{symbol2.base()}
+				coloredWords = append(coloredWords, curWord)
+				curWord = []symbol{symbol2}
 				symbol1 = tape1.pop()
 				symbol2 = tape2.pop()
 			} else {
-				curWord = append(curWord, symbol2.base())
+				curWord = append(curWord, symbol2)
 				symbol2 = tape2.pop()
 			}
 		}
 	}
 	if symbol1 != nil {
-		return nil
+		return nil, nil
+	}
+	coloredWords = append(coloredWords, curWord)
+	if len(coloredWords)%2 == 0 {
+		coloredWords = append(coloredWords, []symbol{})
+	}
+
+	words := make([]word, len(coloredWords))
+	multipliers := make([]int, len(coloredWords)/2)
+	growing := false
+	for i, coloredWord := range coloredWords {
+		if i%2 == 1 {
+			period := findPeriod(coloredWord)
+			multipliers[i/2] = len(coloredWord) / period
+			growing = growing || multipliers[i/2] > 1
+			coloredWord = coloredWord[:period]
+		}
+		words[i] = word{}
+		for _, sy := range coloredWord {
+			words[i] = append(words[i], sy.base())
+		}
+	}
+	if !growing {
+		multipliers = nil
 	}
-	words = append(words, curWord)
-	if len(words)%2 == 0 {
-		words = append(words, word{})
+	return words, multipliers
+}
+
+func expandRepeaters(words []word, multipliers []int) []word {
+	expandedWords := make([]word, len(words))
+	copy(expandedWords, words)
+	for i, multiplier := range multipliers {
+		expandedWords[2*i+1] = words[2*i+1].repeat(multiplier)
+	}
+	return expandedWords
+}
+
+//the length of the shortest prefix that the word is a power of
+func findPeriod(coloredWord []symbol) int {
+	for period := 1; period < len(coloredWord); period++ {
+		if len(coloredWord)%period != 0 {
+			continue
+		}
+		periodic := true
+		for i := period; i < len(coloredWord) && periodic; i++ {
+			periodic = reflect.DeepEqual(coloredWord[i], coloredWord[i-period])
+		}
+		if periodic {
+			return period
+		}
 	}
-	return words
+	return len(coloredWord)
 }
 
-func findStart(tm turingMachine, record record, bufSize int, words []word, stepLimit int) initialConditions {
+func findStart(tm turingMachine, record record, bufSize int, words []word, multipliers []int, stepLimit int) initialConditions {
 	startState := record.state
 	startPos := 0
 	startTape := make([]baseSymbol, bufSize+len(words[0])+1)
@@ -265,21 +388,156 @@ func findStart(tm turingMachine, record record, bufSize int, words []word, stepL
 	copy(buffer, actualTape[len(actualTape)-bufSize:])
 	words[0] = actualTape[:len(actualTape)-bufSize]
 	start := initialConditions{
-		Steps:  actualSteps + record.steps,
-		Words:  words,
-		State:  actualState,
-		Buffer: buffer,
+		Steps:       actualSteps + record.steps,
+		Words:       words,
+		State:       actualState,
+		Buffer:      buffer,
+		Pos:         1,
+		Dir:         R,
+		Multipliers: multipliers,
 	}
 	return start
 }
 
-func findRules(tm turingMachine, start initialConditions, stepLimit int) []transitionRule {
-	if len(start.Words) < 3 {
+//explainCycle expands the rules of a cycle from start. If the walls don't come back after one cycle,
+//the cycle is followed through up to maxVariants differently shaped walls until it gets back to start.
+func explainCycle(tm turingMachine, start initialConditions, stepLimit int) ([]transitionRule, []fullVariant, error) {
+	rules, end, err := expandRules(tm, start, stepLimit)
+	if err != nil {
+		return nil, nil, &stageError{stageRules, -1, err}
+	}
+	variants := []fullVariant{}
+	cur := start
+	for !checkInduction(end.state, end.dir, end.pos, end.buffer, end.words, end.stub, cur, start) {
+		if len(variants) == maxVariants {
+			return nil, nil, &stageError{stageInduction, -1, fmt.Errorf("the walls take more than %d shapes", maxVariants)}
+		}
+		next, ok := findVariant(end, cur)
+		if !ok {
+			return nil, nil, &stageError{stageInduction, -1, fmt.Errorf("the cycle of %d rules doesn't end in C(n+1) of any variant", len(rules))}
+		}
+		var nextRules []transitionRule
+		nextRules, end, err = expandRules(tm, next, stepLimit)
+		if err != nil {
+			return nil, nil, &stageError{stageRules, -1, fmt.Errorf("variant %d: %w", len(variants), err)}
+		}
+		variants = append(variants, fullVariant{next, nextRules})
+		cur = next
+	}
+	if len(variants) == 0 {
+		return rules, nil, nil
+	}
+	return rules, variants, nil
+}
+
+//findVariant describes the configuration at the end of a cycle from start as C(n+1) of a new variant.
+//The new copies of the repeaters are split off the walls next to them and nothing may be left of the stub.
+func findVariant(end cycleEnd, start initialConditions) (initialConditions, bool) {
+	if end.pos%2 != 1 {
+		return initialConditions{}, false
+	}
+	split := end.pos
+	if end.dir == L {
+		split += 1
+	}
+	leftWords := append([]word{}, end.words[:split]...)
+	rightWords := append([]word{}, end.words[split:]...)
+	rightStart := split
+	switch end.dir {
+	case L:
+		leftWords = append(leftWords, end.stub)
+	case R:
+		rightWords = append([]word{end.stub}, rightWords...)
+		rightStart -= 1
+	}
+	leftAlign(leftWords)
+	rightAlign(rightWords)
+	for i := 1; i < len(leftWords); i += 2 {
+		multiplier, _ := start.repetitions(i)
+		copies := leftWords[i].repeat(multiplier)
+		if len(leftWords[i+1]) < len(copies) || !reflect.DeepEqual(leftWords[i+1][:len(copies)], copies) {
+			return initialConditions{}, false
+		}
+		leftWords[i+1] = leftWords[i+1][len(copies):]
+	}
+	for i := 1; i < len(rightWords); i += 2 {
+		multiplier, _ := start.repetitions(rightStart + i)
+		copies := rightWords[i].repeat(multiplier)
+		wall := rightWords[i-1]
+		if len(wall) < len(copies) || !reflect.DeepEqual(wall[len(wall)-len(copies):], copies) {
+			return initialConditions{}, false
+		}
+		rightWords[i-1] = wall[:len(wall)-len(copies)]
+	}
+
+	words := []word{}
+	switch end.dir {
+	case L:
+		if len(leftWords[len(leftWords)-1]) != 0 {
+			return initialConditions{}, false
+		}
+		words = append(append(words, leftWords[:len(leftWords)-1]...), rightWords...)
+	case R:
+		if len(rightWords[0]) != 0 {
+			return initialConditions{}, false
+		}
+		words = append(append(words, leftWords...), rightWords[1:]...)
+	}
+	return initialConditions{
+		Words:       words,
+		State:       end.state,
+		Buffer:      end.buffer,
+		Pos:         end.pos,
+		Dir:         end.dir,
+		Multipliers: start.Multipliers,
+		Offsets:     start.Offsets,
+	}, true
+}
+
+//findRules expands the rules of a cycle from start that has to end in C(n+1) of next
+func findRules(tm turingMachine, start initialConditions, next initialConditions, stepLimit int) []transitionRule {
+	rules, err := explainRules(tm, start, next, stepLimit)
+	if err != nil {
 		return nil
 	}
+	return rules
+}
+
+//explainRules is findRules with the reason why the rules can't be found
+func explainRules(tm turingMachine, start initialConditions, next initialConditions, stepLimit int) ([]transitionRule, error) {
+	rules, end, err := expandRules(tm, start, stepLimit)
+	if err != nil {
+		return nil, err
+	}
+	if !checkInduction(end.state, end.dir, end.pos, end.buffer, end.words, end.stub, start, next) {
+		return nil, fmt.Errorf("the cycle of %d rules doesn't end in C(n+1)", len(rules))
+	}
+	return rules, nil
+}
+
+//the configuration C'(n) after applying the rules of a cycle to C(n)
+type cycleEnd struct {
+	state  tmState
+	dir    direction
+	pos    int
+	buffer word
+	words  []word
+	stub   word
+}
+
+func expandRules(tm turingMachine, start initialConditions, stepLimit int) ([]transitionRule, cycleEnd, error) {
+	if len(start.Words) < 3 {
+		return nil, cycleEnd{}, errors.New("C(n) needs at least 3 words")
+	}
+	if stepLimit <= 0 {
+		return nil, cycleEnd{}, errors.New("the cycle needs a positive number of steps")
+	}
+	if start.pos() < 0 || start.pos() >= len(start.Words) {
+		return nil, cycleEnd{}, fmt.Errorf("position %d is outside of C(n)", start.pos())
+	}
 	curState := start.State
-	curDir := R
-	curGlobalPos := 1
+	curDir := start.Dir
+	curGlobalPos := start.pos()
 	curBuffer := start.Buffer
 	curWords := make([]word, len(start.Words))
 	copy(curWords, start.Words)
@@ -307,18 +565,27 @@ func findRules(tm turingMachine, start initialConditions, stepLimit int) []trans
 		endWord := word{}
 		endStub := word{}
 		bufSize := len(start.Buffer)
-		switch endPos {
-		case -1:
+		switch {
+		case endPos == -1:
 			endDir = L
 			endBuffer = append(endBuffer, endTape[:bufSize]...)
 			endWord = append(endWord, endTape[bufSize:]...)
-		default:
+		case endPos == len(endTape) || start.Dir == R:
 			if endPos-bufSize < 0 {
-				return nil
+				return nil, cycleEnd{}, fmt.Errorf("rule %d ends with too few symbols for the buffer", len(rules))
 			}
 			endWord = append(endWord, endTape[:endPos-bufSize]...)
 			endBuffer = append(endBuffer, endTape[endPos-bufSize:endPos]...)
 			endStub = append(endStub, endTape[endPos:]...)
+		default:
+			//the last rule stops early with the stub left of the buffer
+			endDir = L
+			if endPos+1+bufSize > len(endTape) {
+				return nil, cycleEnd{}, fmt.Errorf("rule %d ends with too few symbols for the buffer", len(rules))
+			}
+			endStub = append(endStub, endTape[:endPos+1]...)
+			endBuffer = append(endBuffer, endTape[endPos+1:endPos+1+bufSize]...)
+			endWord = append(endWord, endTape[endPos+1+bufSize:]...)
 		}
 
 		rule := transitionRule{
@@ -335,7 +602,7 @@ func findRules(tm turingMachine, start initialConditions, stepLimit int) []trans
 			Stub:        endStub,
 		}
 		if len(rules)%2 == 0 && !checkChainRule(rule) {
-			return nil
+			return nil, cycleEnd{}, fmt.Errorf("rule %d is no chain rule", len(rules))
 		}
 		rules = append(rules, rule)
 		stepLimit -= steps
@@ -351,12 +618,89 @@ func findRules(tm turingMachine, start initialConditions, stepLimit int) []trans
 			curGlobalPos += 1
 		}
 		if curGlobalPos < 0 || curGlobalPos >= len(curWords) {
-			return nil
+			return nil, cycleEnd{}, fmt.Errorf("rule %d leaves the tape", len(rules)-1)
 		}
 	}
-	actualStub := rules[len(rules)-1].Stub
-	if !checkInduction(curState, curDir, curGlobalPos, curBuffer, curWords, actualStub, start) {
-		return nil
+	return rules, cycleEnd{curState, curDir, curGlobalPos, curBuffer, curWords, rules[len(rules)-1].Stub}, nil
+}
+
+//expandShortCert derives the rules of every variant of a short certificate
+func expandShortCert(cert shortCert) (fullCert, error) {
+	tm := cert.Tm
+	if cert.Mirror {
+		tm = tm.mirror()
 	}
-	return rules
+	starts := []initialConditions{cert.Start}
+	stepLimits := []int{cert.CycleSteps}
+	for _, variant := range cert.Variants {
+		starts = append(starts, variant.Start)
+		stepLimits = append(stepLimits, variant.CycleSteps)
+	}
+	for _, stepLimit := range stepLimits {
+		if stepLimit < 0 || stepLimit > maxCertSteps {
+			return fullCert{}, fmt.Errorf("a cycle of %d steps", stepLimit)
+		}
+	}
+	rules := make([][]transitionRule, len(starts))
+	for i, start := range starts {
+		var err error
+		rules[i], err = explainRules(tm, start, starts[(i+1)%len(starts)], stepLimits[i])
+		if err != nil {
+			if i > 0 {
+				return fullCert{}, fmt.Errorf("variant %d: %w", i-1, err)
+			}
+			return fullCert{}, err
+		}
+	}
+	full := fullCert{
+		Tm:      cert.Tm,
+		Mirror:  cert.Mirror,
+		Start:   cert.Start,
+		Rules:   rules[0],
+		Mapping: cert.Mapping,
+	}
+	for i, variant := range cert.Variants {
+		full.Variants = append(full.Variants, fullVariant{variant.Start, rules[i+1]})
+	}
+	return full, nil
+}
+
+//shortenFullCert is the inverse of expandShortCert. It fails if the rules of the full certificate
+//aren't the ones derived from its short version, so no information gets lost.
+func shortenFullCert(cert fullCert) (shortCert, error) {
+	short := cert.short()
+	full, err := expandShortCert(short)
+	if err != nil {
+		return shortCert{}, err
+	}
+	if err := compareRules(cert.Rules, full.Rules); err != nil {
+		return shortCert{}, err
+	}
+	if len(cert.Variants) != len(full.Variants) {
+		return shortCert{}, fmt.Errorf("%d variants instead of %d", len(full.Variants), len(cert.Variants))
+	}
+	for i := range cert.Variants {
+		if err := compareRules(cert.Variants[i].Rules, full.Variants[i].Rules); err != nil {
+			return shortCert{}, fmt.Errorf("variant %d: %w", i, err)
+		}
+	}
+	return short, nil
+}
+
+func compareRules(rules []transitionRule, derived []transitionRule) error {
+	for i := range rules {
+		if i >= len(derived) {
+			return fmt.Errorf("only %d of %d rules can be derived", len(derived), len(rules))
+		}
+		//compared as JSON, so empty and missing words are the same
+		a, _ := json.Marshal(rules[i])
+		b, _ := json.Marshal(derived[i])
+		if string(a) != string(b) {
+			return fmt.Errorf("rule %d differs from the derived rule", i)
+		}
+	}
+	if len(derived) > len(rules) {
+		return fmt.Errorf("%d rules derived instead of %d", len(derived), len(rules))
+	}
+	return nil
 }
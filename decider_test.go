@@ -1,14 +1,1101 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDecider(t *testing.T) {
 	// tm := parseTM("1LB---_0LC1LD_0RD1LC_1RE0LA_1LA0RE")
 	// tm := parseTM("1RB1LC_0LA0RB_1RD1LE_0RB1RC_---0LB")
 	tm := parseTM("1RB1RD_1LC1LE_1RA0LB_0RA---_0RC0RB")
-	if !decideBouncers(tm, 1700, 4) {
+	cert, ok := findBouncer(tm, 1700, 0)
+	if !ok {
+		t.Fatal("not decided")
+	}
+	var b strings.Builder
+	fprintCert(&b, cert, 4)
+	if b.Len() == 0 {
+		t.Error("nothing printed for", tm)
+	}
+}
+
+func TestRightAlign(t *testing.T) {
+	//the first word shares its array with a rule and must not be written through
+	rule := word{0, 0}
+	words := []word{rule[:1], {1}, {1, 0}}
+	rightAlign(words)
+	if rule[1] != 0 {
+		t.Fatal("rightAlign wrote into the array of the rule")
+	}
+	if len(words[0]) != 2 || words[0][1] != 1 || len(words[1]) != 1 || words[1][0] != 1 || len(words[2]) != 1 || words[2][0] != 0 {
+		t.Fatal("wrong alignment", words)
+	}
+
+	//an empty repeater has no symbol to shift
+	words = []word{{0}, {}, {1}}
+	rightAlign(words)
+	if len(words[0]) != 1 || len(words[1]) != 0 || len(words[2]) != 1 {
+		t.Fatal("an empty word was shifted", words)
+	}
+}
+
+func TestDeciderRightRecords(t *testing.T) {
+	tm := parseTM("1RB---_0LB0RC_0RD0LD_1LE0RE_1LA1LC")
+	if _, ok := findBouncer(tm, 1000, 0); !ok {
 		t.Fail()
 	}
 }
+
+func TestDeciderMultipliers(t *testing.T) {
+	//the second repeater grows by two copies of 1 per cycle
+	tm := parseTM("1RB---_0RC0LC_1LC0LD_1RB1LD")
+	cert, ok := findBouncer(tm, 1000, 0)
+	if !ok {
+		t.Fatal("not decided")
+	}
+	if !reflect.DeepEqual(cert.Start.Multipliers, []int{1, 2}) {
+		t.Error("wrong multipliers", cert.Start.Multipliers)
+	}
+}
+
+func TestVerifyMultipliers(t *testing.T) {
+	//C(n) = 11 1^n 110 1^(2n+2) with the head on the first repeater
+	short := shortCert{}
+	err := json.Unmarshal([]byte(`{"Tm":"1RB---_0RC0LC_1LC0LD_1RB1LD","Start":{"Steps":31,"Words":["11","1","110","1",""],"State":"C","Buffer":"0","Pos":1,"Dir":"R","Multipliers":[1,2],"Offsets":[0,2]},"CycleSteps":29}`), &short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := expandShortCert(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyBouncer(cert) {
+		t.Fatal("valid certificate rejected")
+	}
+	if got := string(mustText(cert.Start.power(3, 1))); got != "1111" {
+		t.Error("wrong power", got)
+	}
+
+	wrong := map[string]func(*initialConditions){
+		"offset":          func(start *initialConditions) { start.Offsets = []int{0, 3} },
+		"multiplier":      func(start *initialConditions) { start.Multipliers = []int{1, 1} },
+		"zero multiplier": func(start *initialConditions) { start.Multipliers = []int{0, 2} },
+		"negative offset": func(start *initialConditions) { start.Offsets = []int{-1, 2} },
+		"missing offset":  func(start *initialConditions) { start.Offsets = []int{0} },
+		//C(0) would need gigabytes
+		"huge offset":     func(start *initialConditions) { start.Offsets = []int{0, 4000000000} },
+		"huge multiplier": func(start *initialConditions) { start.Multipliers = []int{1, 4000000000} },
+		"huge step count": func(start *initialConditions) { start.Steps = 1 << 40 },
+	}
+	for name, change := range wrong {
+		changed := cert
+		changed.Start.Multipliers = append([]int{}, cert.Start.Multipliers...)
+		changed.Start.Offsets = append([]int{}, cert.Start.Offsets...)
+		change(&changed.Start)
+		if verifyBouncer(changed) {
+			t.Error("accepted a certificate with a wrong", name)
+		}
+	}
+}
+
+func TestVerifyVariants(t *testing.T) {
+	//C(n) = 31 1^n 2 and 21 1^n 2, the left wall switches between 3 and 2 on every bounce
+	short := shortCert{}
+	err := json.Unmarshal([]byte(`{"Tm":"2LB1RA1RA---_2RA1LB3RA2RA","Start":{"Steps":7,"Words":["31","1","2"],"State":"A","Buffer":"","Pos":1,"Dir":"R"},"CycleSteps":8,"Variants":[{"Start":{"Steps":0,"Words":["21","1","2"],"State":"A","Buffer":"","Pos":1,"Dir":"R"},"CycleSteps":8}]}`), &short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := expandShortCert(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := explainVariants(cert.Tm, cert); err != nil {
+		t.Fatal("valid certificate rejected:", err)
+	}
+	if !verifyBouncer(cert) {
+		t.Fatal("valid certificate rejected")
+	}
+	rules, variants, err := explainCycle(cert.Tm, cert.Start, short.CycleSteps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cert.Rules, rules) || !reflect.DeepEqual(cert.Variants, variants) {
+		t.Errorf("the cycle isn't the one of the certificate, got %v %v", rules, variants)
+	}
+
+	missing := cert
+	missing.Variants = nil
+	if checkVariants(cert.Tm, missing) {
+		t.Error("accepted a certificate without its variant")
+	}
+	wrong := cert
+	wrong.Variants = []fullVariant{{cert.Start, cert.Rules}}
+	if checkVariants(cert.Tm, wrong) {
+		t.Error("accepted a certificate with a wrong variant")
+	}
+}
+
+func TestDeciderRecordSpacing(t *testing.T) {
+	//only every second record fits
+	tm := parseTM("1RB0LB---_1LA0RA---")
+	if _, ok := findBouncer(tm, 1000, 1); ok {
+		t.Error("decided with consecutive records")
+	}
+	if _, ok := findBouncer(tm, 1000, 2); !ok {
+		t.Error("not decided with records two apart")
+	}
+}
+
+func TestMinimizeCert(t *testing.T) {
+	short := shortCert{}
+	err := json.Unmarshal([]byte(`{"Tm":"1RB---_0RC0LC_1LC0LD_1RB1LD","Start":{"Steps":196,"Words":["1","1","111111101111111111","11","11"],"State":"C","Buffer":"10","Pos":1,"Dir":"R"},"CycleSteps":49}`), &short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := expandShortCert(short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Mapping = &tmMapping{States: []tmState{A, B, C, D}, Symbols: []baseSymbol{0, 1}}
+	//the same proof for the mirrored tm has the same canonical version
+	mirrored := cert.mirror()
+	mirrored.Tm = cert.Tm
+	mirrored.Mirror = true
+	mirrored.Mapping = cert.Mapping
+	expected := `{"Format":"bouncer-short","Version":2,"Tm":"1RB---_0RC0LC_1LC0LD_1RB1LD","Mirror":false,"Start":{"Steps":31,"Words":["11","1","110","1",""],"State":"C","Buffer":"0","Pos":1,"Dir":"R","Multipliers":[1,2],"Offsets":[0,2]},"CycleSteps":29,"Mapping":{"States":["A","B","C","D"],"Symbols":[0,1],"Mirror":false}}`
+	for _, cert := range []fullCert{cert, mirrored} {
+		if !verifyBouncer(cert) {
+			t.Fatal("invalid test certificate")
+		}
+		minimal := minimizeCert(cert)
+		if !verifyBouncer(minimal) {
+			t.Fatal("the minimal certificate doesn't verify")
+		}
+		b, _ := json.Marshal(minimal.short())
+		if string(b) != expected {
+			t.Errorf("expected %s\ngot %s", expected, b)
+		}
+	}
+}
+
+func TestConvertCerts(t *testing.T) {
+	cert := shortCert{}
+	err := json.Unmarshal([]byte(`{"Tm":"1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD","Mirror":false,"Start":{"Steps":70,"Words":["1111111","1","10"],"State":"B","Buffer":"","Pos":1,"Dir":"R"},"CycleSteps":19}`), &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := expandShortCert(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := shortenFullCert(full)
+	if err != nil || !reflect.DeepEqual(short, cert) {
+		t.Fail()
+	}
+	full.Rules[1].Steps += 1
+	if _, err := shortenFullCert(full); err == nil {
+		t.Fail()
+	}
+}
+
+func TestCertVersion(t *testing.T) {
+	cert := shortCert{}
+	if err := json.Unmarshal([]byte(`{"Version":3,"Tm":"1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD"}`), &cert); err == nil {
+		t.Error("accepted an unknown version")
+	}
+	if err := json.Unmarshal([]byte(`{"Format":"bouncer-full","Tm":"1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD"}`), &cert); err == nil {
+		t.Error("accepted a full certificate as short certificate")
+	}
+	schema, err := json.MarshalIndent(certSchema(), "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipped, err := os.ReadFile("certificate.schema.json")
+	if err != nil || strings.TrimSpace(string(shipped)) != string(schema) {
+		t.Error("certificate.schema.json is out of date, regenerate it with -schema")
+	}
+}
+
+func TestBinaryCerts(t *testing.T) {
+	text, err := os.ReadFile("testFullCert.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	var buf bytes.Buffer
+	cw := newCertWriter(&buf)
+	for _, line := range lines {
+		cert := fullCert{}
+		if err := json.Unmarshal([]byte(line), &cert); err != nil {
+			t.Fatal(err)
+		}
+		if err := cw.writeFull(cert); err != nil {
+			t.Fatal(err)
+		}
+		if err := cw.writeShort(cert.short()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := newCertReader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		cert := fullCert{}
+		_ = json.Unmarshal([]byte(line), &cert)
+		for _, expected := range []interface{}{cert, cert.short()} {
+			decoded, err := cr.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, _ := json.Marshal(expected)
+			b, _ := json.Marshal(decoded)
+			if string(a) != string(b) {
+				t.Errorf("expected %s\ngot %s", a, b)
+			}
+		}
+	}
+	if _, err := cr.next(); err != io.EOF {
+		t.Error("expected the end of the certificates", err)
+	}
+
+	//a symbol that doesn't fit into the bits of a symbol of the tm would be changed by the encoding
+	cert := fullCert{}
+	_ = json.Unmarshal([]byte(lines[0]), &cert)
+	cert.Start.Words = append([]word{}, cert.Start.Words...)
+	cert.Start.Words[0] = word{1, baseSymbol(cert.Tm.numSymbols)}
+	if _, err := encodeFull(cert); err == nil {
+		t.Error("encoded a symbol outside of the tm")
+	}
+	if _, err := encodeShort(cert.short()); err == nil {
+		t.Error("encoded a symbol outside of the tm")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	path := t.TempDir() + "/test.bna"
+	a, err := openArchive(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, record, err := parseCertLine(`7 {"Tm":"1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD","Mirror":false,"Start":{"Steps":70,"Words":["1111111","1","10"],"State":"B","Buffer":"","Pos":1,"Dir":"R"},"CycleSteps":19}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []bool{true, false} {
+		if added, err := a.add(id, record); err != nil || added != expected {
+			t.Errorf("add %d: expected %v, got %v %v", i, expected, added, err)
+		}
+	}
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//an interrupted add leaves a cut off entry at the end
+	complete, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(append([]byte{}, complete...), 100, 1, 2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err = openArchive(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"7", "1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD"} {
+		entries, err := a.lookup(key)
+		if err != nil || len(entries) != 1 || entries[0].id != 7 || !bytes.Equal(entries[0].record, record) {
+			t.Errorf("lookup %s: %v %v", key, entries, err)
+		}
+	}
+	a.close()
+	if info, _ := os.Stat(path); info.Size() != int64(len(complete))+3 {
+		t.Error("a read-only archive was changed")
+	}
+	a, err = openArchive(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.close()
+	if info, _ := os.Stat(path); info.Size() != int64(len(complete)) {
+		t.Error("the cut off entry wasn't removed")
+	}
+
+	//an entry with an id that can't be read makes the archive damaged instead of being cut off
+	damaged := append(append([]byte{}, complete...), 3, 0xff, 0xff, 0xff)
+	damaged = append(damaged, complete[len(archiveMagic):]...)
+	if err := os.WriteFile(path, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, writable := range []bool{false, true} {
+		if _, err := openArchive(path, writable); err == nil {
+			t.Error("opened a damaged archive")
+		}
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(damaged)) {
+		t.Error("a damaged archive was cut off")
+	}
+
+	if _, err := (archiveEntry{-1, []byte{'F', 9}}).text(); err == nil {
+		t.Error("printed an entry that can't be decoded")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tm := parseTM("1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD")
+	permuted := tm.transform(tmMapping{States: []tmState{A, E, C, B, D}, Symbols: []baseSymbol{0, 1}, Mirror: true})
+	normalized, _ := tm.normalize(true)
+	normalizedPermuted, mapping := permuted.normalize(true)
+	if normalized.String() != normalizedPermuted.String() {
+		t.Errorf("%v and %v have different normal forms", tm, permuted)
+	}
+	cert, ok := findBouncer(normalizedPermuted, 10000, 0)
+	if !ok {
+		t.Fatal("no certificate for", normalizedPermuted)
+	}
+	cert.Mapping = &mapping
+	original, err := cert.original()
+	if err != nil || original.Tm.String() != permuted.String() || !verifyBouncer(original) {
+		t.Error("the certificate doesn't transport back to", permuted, err)
+	}
+
+	//symbols and states that aren't in the tm can't be relabelled
+	for _, change := range []func(*fullCert){
+		func(cert *fullCert) { cert.Start.Words[0] = word{7} },
+		func(cert *fullCert) { cert.Rules[1].Stub = word{-1} },
+		func(cert *fullCert) { cert.Rules[0].EndState = 9 },
+	} {
+		var b bytes.Buffer
+		changed := cert
+		changed.Start.Words = append([]word{}, cert.Start.Words...)
+		changed.Rules = append([]transitionRule{}, cert.Rules...)
+		change(&changed)
+		if _, err := changed.original(); err == nil {
+			t.Error("transported a certificate with labels outside of the tm")
+		}
+		verifyCert(&b, changed, 1, false, true)
+		if b.Len() != 0 {
+			t.Error("printed a certificate with labels outside of the tm", b.String())
+		}
+	}
+}
+
+func TestTransportCert(t *testing.T) {
+	tm := parseTM("1RB---_0LC1RB_1RA1LD_1RB1LD_0RB1LD")
+	cert, ok := findBouncer(tm, 10000, 0)
+	if !ok {
+		t.Fatal("no certificate for", tm)
+	}
+	to := tm.transform(tmMapping{States: []tmState{A, D, B, E, C}, Symbols: []baseSymbol{0, 1}, Mirror: true})
+	transported, err := transportCert(cert, to)
+	if err != nil || transported.Tm.String() != to.String() {
+		t.Error(err)
+	}
+	if _, err := transportCert(cert, parseTM("1RB---_0LD0LB_1RD0RB_1RD1LB_1RE1LA")); err == nil {
+		t.Error("transported a certificate to a different machine")
+	}
+	wrong := cert
+	wrong.Start.Words = append([]word{}, cert.Start.Words...)
+	wrong.Start.Words[0] = word{5}
+	if _, err := transportCert(wrong, to); err == nil || !strings.Contains(err.Error(), "is invalid") {
+		t.Error("transported a certificate with a symbol outside of the tm", err)
+	}
+	wrong.Start.Words[0] = word{1, 1, 1}
+	if _, err := transportCert(wrong, to); err == nil || !strings.Contains(err.Error(), "doesn't verify:") {
+		t.Error("transported a certificate that doesn't verify", err)
+	}
+}
+
+func TestEnumerateTNF(t *testing.T) {
+	count := 0
+	enumerateTNF(3, 2, 1000, func(tm turingMachine) {
+		count++
+		if normalized, _ := tm.normalize(false); normalized.String() != tm.String() {
+			t.Errorf("%v is not in Tree Normal Form", tm)
+		}
+	})
+	if count != 2198 {
+		t.Errorf("expected 2198 machines, got %d", count)
+	}
+}
+
+func TestMachineRun(t *testing.T) {
+	text, err := os.ReadFile("testBouncers.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//bouncers, a cycler, a translated cycler and a tm that halts
+	machines := append(strings.Fields(string(text))[:5], "1LC1RC_0RA1LB_0LB0RD_0RB1LE_1RA0LD", "1LC---_0RD1RB_1LC1LD_1RD1RA_0LA0RD", "1RB1LB_1LA---")
+	growth := map[direction]bool{L: true, R: true}
+	for _, m := range machines {
+		tm := parseTM(m)
+		for _, steps := range []int{0, 1, 7, 100, 1000} {
+			state, pos, tape, actualSteps := runTm(tm, 0, 0, []baseSymbol{0}, steps, growth)
+			run := newMachineRun(tm)
+			halted := !run.run(steps)
+			if halted != (actualSteps < steps) {
+				t.Fatalf("%s after %d steps: halted %v, runTm stopped after %d steps", m, steps, halted, actualSteps)
+			}
+			runTape := run.tape[run.minPos+run.offset : run.maxPos+run.offset+1]
+			if run.state != state || run.pos-run.minPos != pos || !reflect.DeepEqual(runTape, tape) {
+				t.Errorf("%s after %d steps: %v at %d of %v instead of %v at %d of %v", m, steps, run.state, run.pos-run.minPos, runTape, state, pos, tape)
+			}
+		}
+	}
+}
+
+func TestCyclers(t *testing.T) {
+	cycler := parseTM("1RB1LB_0LC0RD_0RB1LA_0LC1RE_1LA0RD")
+	cert, ok := decideCycler(cycler, 1000)
+	if !ok {
+		t.Error("no cycler certificate for", cycler)
+	}
+	cert.Period++
+	if verifyCycler(cert) {
+		t.Error("accepted a wrong period")
+	}
+	translated := parseTM("1RB---_1RC1RD_0RB0LB_1LD1LA_0RA0LD")
+	tCert, ok := decideTranslatedCycler(translated, 1000)
+	if !ok || !tCert.Mirror {
+		t.Error("no translated cycler certificate for", translated)
+	}
+	if _, ok := decideTranslatedCycler(parseTM("1RB1RD_1LC1LE_1RA0LB_0RA---_0RC0RB"), 1700); ok {
+		t.Error("a bouncer is no translated cycler")
+	}
+}
+
+func TestDeciderChain(t *testing.T) {
+	if _, err := newChain([]string{"bouncer", "none"}, false, 0); err == nil {
+		t.Error("accepted an unknown decider")
+	}
+	chain, err := newChain([]string{"cycler", "tcycler", "bouncer"}, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tmText, format := range map[string]string{
+		"1RB1LB_0LC0RD_0RB1LA_0LC1RE_1LA0RD": cyclerFormat,
+		"1RB---_1RC1RD_0RB0LB_1LD1LA_0RA0LD": translatedCyclerFormat,
+		"1RB1RD_1LC1LE_1RA0LB_0RA---_0RC0RB": fullFormat,
+	} {
+		cert, ok := decideChain(chain, parseTM(tmText), 10000)
+		if !ok {
+			t.Error("the chain didn't decide", tmText)
+			continue
+		}
+		b, err := json.Marshal(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := findVerifier(b)
+		if err != nil || v.format() != format {
+			t.Errorf("expected a %s certificate for %s, got %s", format, tmText, b)
+			continue
+		}
+		if _, ok, err := v.verify(b); !ok || err != nil {
+			t.Errorf("the %s certificate for %s doesn't verify", format, tmText)
+		}
+	}
+}
+
+func TestDeciderChainMapping(t *testing.T) {
+	chain, err := newChain([]string{"cycler", "tcycler"}, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the machines of TestCyclers with the states B and C swapped and L and R swapped
+	for _, tmText := range []string{"1LC1RC_0RA1LB_0LB0RD_0RB1LE_1RA0LD", "1LC---_0RD1RB_1LC1LD_1RD1RA_0LA0RD"} {
+		var b strings.Builder
+		scanMachine(&b, tmText, chain, 1000, 1, true, true)
+		v, err := findVerifier([]byte(b.String()))
+		if err != nil {
+			t.Fatal(tmText, err)
+		}
+		cert, ok, err := v.verify([]byte(b.String()))
+		if err != nil || !ok {
+			t.Fatal("the certificate doesn't verify", b.String())
+		}
+		var original certificate
+		switch cert := cert.(type) {
+		case cyclerCert:
+			if cert.Mapping == nil {
+				t.Error("no mapping in", b.String())
+			}
+			original, ok = cert.original(), verifyCycler(cert.original())
+		case translatedCyclerCert:
+			if cert.Mapping == nil {
+				t.Error("no mapping in", b.String())
+			}
+			original, ok = cert.original(), verifyTranslatedCycler(cert.original())
+		}
+		if !ok {
+			t.Error("the certificate for the original tm doesn't verify", b.String())
+		}
+		var text strings.Builder
+		original.print(&text, 0)
+		if strings.TrimSpace(text.String()) != tmText {
+			t.Errorf("expected the certificate for %s, got %s", tmText, text.String())
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tm := parseTM("1RB---_0LB0RC_0RD0LD_1LE0RE_1LA1LC")
+	cert, ok := scanTM(tm, 1000, false, 0)
+	if !ok {
+		t.Fatal("no certificate for", tm)
+	}
+	d := buildDiagram(tm, 500)
+	d.addRecords(tm)
+	d.addSegments(cert)
+	if len(d.rows) != 501 || len(d.records) == 0 {
+		t.Errorf("expected 501 rows with records, got %d rows and %d records", len(d.rows), len(d.records))
+	}
+	configs := map[int]bool{}
+	for _, segment := range d.segments {
+		configs[segment.step] = true
+		if segment.from < d.minPos || segment.to > d.maxPos+1 || segment.from >= segment.to {
+			t.Errorf("segment %v is outside of the diagram", segment)
+		}
+	}
+	if len(configs) < 3 {
+		t.Errorf("expected C(0), C(1) and C(2) within 500 steps, found %d", len(configs))
+	}
+
+	var b bytes.Buffer
+	if err := d.writePNG(&b, 2); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dy() != 2*len(d.rows) || img.Bounds().Dx() != 2*(d.maxPos-d.minPos+1)+8 {
+		t.Errorf("unexpected size %v", img.Bounds())
+	}
+	b.Reset()
+	if err := d.writeSVG(&b, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `class="repeater"`) || !strings.HasSuffix(b.String(), "</svg>\n") {
+		t.Error("the SVG is missing the segmentation")
+	}
+}
+
+func TestColorDumps(t *testing.T) {
+	dumps := findColorDumps(parseTM("1RB1RD_1LC1LE_1RA0LB_0RA---_0RC0RB"), 1700, 0)
+	colored, split := 0, 0
+	for _, dump := range dumps {
+		if dump.rejected != "" {
+			continue
+		}
+		colored++
+		if len(dump.tape1) == 0 || len(dump.tape2) <= len(dump.tape1) {
+			t.Errorf("%s: the second tape should be longer than the first", dump.name())
+		}
+		if dump.words != nil {
+			split++
+		}
+		var text bytes.Buffer
+		dump.writeText(&text)
+		if !strings.Contains(text.String(), "tape2: ") {
+			t.Errorf("%s: the colored tapes are missing in\n%s", dump.name(), text.String())
+		}
+		var b bytes.Buffer
+		if err := dump.writePNG(&b); err != nil {
+			t.Error(err)
+		}
+	}
+	if colored == 0 || split == 0 || colored == len(dumps) {
+		t.Errorf("expected rejected, colored and split quadruples, got %d colored and %d split of %d", colored, split, len(dumps))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	entries := traceBouncer(parseTM("1RB1RD_1LC1LE_1RA0LB_0RA---_0RC0RB"), 1700, 0)
+	if len(entries) != 4 || entries[3].err != nil || entries[0].stage() != stageStates || entries[1].stage() != stageProgression {
+		t.Errorf("unexpected trace %v", entries)
+	}
+	//the decided quadruple prints its records with the repeater it was split into
+	if len(entries) == 4 && !strings.Contains(entries[3].records[0].compressed(entries[3].repeaters), "(001001)^3") {
+		t.Errorf("expected the repeater 001001, got %s with %v", entries[3].records[0].compressed(entries[3].repeaters), entries[3].repeaters)
+	}
+	entries = traceBouncer(parseTM("1RB---_0LB0LC_1LD0RE_0RE0LA_1RC1RD"), 300, 0)
+	if len(entries) == 0 || entries[0].stage() != stageRepeaters || !strings.Contains(entries[0].String(), "findRepeaters with buffer 2") {
+		t.Errorf("unexpected trace %v", entries)
+	}
+}
+
+func TestReport(t *testing.T) {
+	text, err := os.ReadFile("testBouncers.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workTokens := make(chan struct{}, 2)
+	workTokens <- struct{}{}
+	workTokens <- struct{}{}
+	dir := t.TempDir()
+	if err := runReport(bufio.NewReader(bytes.NewReader(text)), io.Discard, workTokens, dir, 300, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	found, err := os.ReadFile(dir + "/findRules.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(found), "\n"); lines != 2 {
+		t.Errorf("expected 2 machines that fail in findRules at 300 steps, got %d", lines)
+	}
+	if _, err := os.Stat(dir + "/fewRecords.txt"); err != nil {
+		t.Error("every bucket should have a file", err)
+	}
+}
+
+func TestCompressWord(t *testing.T) {
+	w := word{}
+	_ = w.UnmarshalText([]byte("11" + strings.Repeat("1000", 37) + "100"))
+	for text, expected := range map[string]string{
+		string(mustText(w)): "11 (1000)^37 100",
+		"0101":              "0101",
+		"000000":            "(0)^6",
+		"":                  "",
+	} {
+		w := word{}
+		_ = w.UnmarshalText([]byte(text))
+		if compressed := compressWord(w); compressed != expected {
+			t.Errorf("expected %s, got %s", expected, compressed)
+		}
+	}
+	//0101011 has too short a run for compressWord, but 01 is a repeater
+	if compressed := compressWordWith(word{0, 1, 0, 1, 0, 1, 1}, []word{{0, 1}}); compressed != "(01)^3 1" {
+		t.Errorf("expected (01)^3 1, got %s", compressed)
+	}
+	if compressed := compressWordWith(w, nil); compressed != "11 (1000)^37 100" {
+		t.Errorf("without repeaters compressWord should be used, got %s", compressed)
+	}
+	start := initialConditions{Words: []word{{0}, {1, 0, 0, 0}, {1, 0, 0}}, State: C, Buffer: word{1, 1}, Pos: 1, Dir: R, Multipliers: []int{2}, Offsets: []int{1}}
+	if text := formatStart(start, -1); text != "0 11 C> (1000)^(2n+1) 100" {
+		t.Error("unexpected C(n)", text)
+	}
+	if text := formatStart(start, 1); text != "0 11 C> (1000)^3 100" {
+		t.Error("unexpected C(1)", text)
+	}
+}
+
+func mustText(w word) []byte {
+	text, _ := w.MarshalText()
+	return text
+}
+
+func TestStepper(t *testing.T) {
+	tm := parseTM("1RB1RD_1LC1LE_1RA0LB_0RA---_0RC0RB")
+	cert, ok := scanTM(tm, 1700, false, 0)
+	if !ok {
+		t.Fatal("no certificate for", tm)
+	}
+	configs, spans := certSchedule(cert, 20000)
+	if len(configs) < 5 || len(spans) != len(configs)*len(cert.Rules) {
+		t.Fatalf("unexpected schedule %v", configs)
+	}
+	//the schedule has to agree with the simulation of the tm at every C(n)
+	d := buildDiagram(tm, configs[4])
+	d.addSegments(cert)
+	found := map[int]bool{}
+	for _, segment := range d.segments {
+		found[segment.step] = true
+	}
+	for _, step := range configs[:5] {
+		if !found[step] {
+			t.Errorf("the tm isn't in C(n) at step %d", step)
+		}
+	}
+
+	var out bytes.Buffer
+	runStepper(tm, &cert, bufio.NewReader(strings.NewReader("c 2\ns 3\nc\ng 1\nq\n")), &out)
+	for _, expected := range []string{fmt.Sprintf("step %d, state %v", configs[2], cert.Start.State), ", at C(2), C(2) of Start, rule 0", ", at C(3)", "step 1, state B"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, out.String())
+		}
+	}
+
+	//a certificate that doesn't verify isn't stepped through
+	b, err := json.Marshal(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.txt")
+	invalid := filepath.Join(dir, "invalid.txt")
+	if err := os.WriteFile(valid, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, bytes.Replace(b, []byte(`"Steps":`), []byte(`"Steps":-`), -1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, loaded, err := loadStepper(valid); err != nil || loaded == nil {
+		t.Error("unable to load the certificate", err)
+	}
+	if _, _, err := loadStepper(invalid); err == nil {
+		t.Error("loaded a certificate with negative steps")
+	}
+}
+
+func TestServer(t *testing.T) {
+	full, err := os.ReadFile("testFullCert.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := os.ReadFile("testShortCert.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullLine := strings.SplitN(string(full), "\n", 2)[0]
+	shortLine := strings.SplitN(string(short), "\n", 2)[0]
+	wrongStart := strings.Replace(shortLine, `"Steps":27`, `"Steps":127`, 1)
+	//C(0) of this one doesn't fit in memory
+	huge := `{"Tm":"1RB---_0RC0LC_1LC0LD_1RB1LD","Start":{"Steps":31,"Words":["11","1","110","1",""],"State":"C","Buffer":"0","Pos":1,"Dir":"R","Multipliers":[1,2],"Offsets":[0,4000000000]},"CycleSteps":29}`
+	body := strings.Join([]string{fullLine, shortLine, wrongStart, "garbage", huge}, "\n")
+
+	workTokens := make(chan struct{}, 2)
+	for i := 0; i < cap(workTokens); i++ {
+		workTokens <- struct{}{}
+	}
+	server := httptest.NewServer(verifyHandler(workTokens))
+	defer server.Close()
+	response, err := http.Post(server.URL, "application/jsonl", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	verdicts := []verdict{}
+	if err := json.NewDecoder(response.Body).Decode(&verdicts); err != nil {
+		t.Fatal(err)
+	}
+	if len(verdicts) != 5 {
+		t.Fatalf("expected 5 verdicts, got %v", verdicts)
+	}
+	if !verdicts[0].Valid || verdicts[0].Format != fullFormat || !verdicts[1].Valid || verdicts[1].Format != shortFormat {
+		t.Errorf("the certificates don't verify: %v", verdicts[:2])
+	}
+	if verdicts[2].Valid || !strings.Contains(verdicts[2].Error, "doesn't reach Start in 127 steps") {
+		t.Errorf("unexpected verdict for the wrong start %v", verdicts[2])
+	}
+	if verdicts[3].Valid || verdicts[3].Index != 4 || verdicts[3].Error == "" {
+		t.Errorf("unexpected verdict for garbage %v", verdicts[3])
+	}
+	if verdicts[4].Valid || verdicts[4].Error == "" {
+		t.Errorf("unexpected verdict for the huge offset %v", verdicts[4])
+	}
+
+	//a single pretty printed certificate is accepted as well
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, []byte(fullLine), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	verifyHandler(workTokens).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/verify", &pretty))
+	if !strings.Contains(recorder.Body.String(), `"Valid":true`) {
+		t.Errorf("unexpected response %s", recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	tooMany := strings.Repeat(shortLine+"\n", maxRequestCerts+1)
+	verifyHandler(workTokens).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader(tooMany)))
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("%d certificates returned %d", maxRequestCerts+1, recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	verifyHandler(workTokens).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/verify", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET returned %d", recorder.Code)
+	}
+}
+
+func TestDistributed(t *testing.T) {
+	text, err := os.ReadFile("testBouncers.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	machines := append(strings.Fields(string(text)), "1RB---_0RC0RC_0RD0RD_0RE0RE_0RA0RA")
+	expected := ""
+	//the input is given as bbchallenge records behind a header that is no valid record, like the database
+	records := bytes.Repeat([]byte{0, 0xDA}, 15)
+	for _, m := range machines {
+		tm := parseTM(m)
+		records = append(records, bbchallengeRecord(tm)...)
+		if _, ok := scanTM(tm, 10000, false, 0); ok {
+			expected += fmt.Sprintln(tm)
+		}
+	}
+	input := bufio.NewReader(bytes.NewReader(records))
+	if !isBbchallenge(input) {
+		t.Fatal("the records aren't recognized")
+	}
+	input, err = machineInput(input, "5x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	done := make(chan error)
+	settings := scanSettings{[]string{"bouncer"}, 10000, false, 0, 0, false, false}
+	go func() {
+		done <- runCoordinator(listener, input, &output, settings, 2, time.Second)
+	}()
+	//a worker that disconnects after it got its batch, the batch has to go to another worker
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	//a worker that gets a batch and never answers, the batch has to go to another worker after the timeout
+	hanging, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hanging.Close()
+	if _, err := bufio.NewReader(hanging).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	workerErrs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			workTokens := make(chan struct{}, 2)
+			for i := 0; i < cap(workTokens); i++ {
+				workTokens <- struct{}{}
+			}
+			workerErrs <- runWorker(listener.Addr().String(), workTokens)
+		}()
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	//a worker that connects after the coordinator is done fails, which is fine
+	for i := 0; i < 2; i++ {
+		if err := <-workerErrs; err != nil {
+			t.Log(err)
+		}
+	}
+	if output.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, output.String())
+	}
+}
+
+func TestWorkerKeepalive(t *testing.T) {
+	//a worker that is busy for longer than the timeout keeps its batch as long as it sends keepalives
+	var output bytes.Buffer
+	c := newCoordinator(&output, 200*time.Millisecond)
+	c.add(&batchRequest{Keepalive: 50 * time.Millisecond})
+	c.closeInput()
+	server, worker := net.Pipe()
+	defer worker.Close()
+	go c.serveWorker(server)
+	go func() {
+		encoder := json.NewEncoder(worker)
+		batch := batchRequest{}
+		if err := json.NewDecoder(worker).Decode(&batch); err != nil {
+			return
+		}
+		for i := 0; i < 10; i++ {
+			time.Sleep(batch.Keepalive)
+			if err := encoder.Encode(batchResult{Index: batch.Index, Working: true}); err != nil {
+				return
+			}
+		}
+		encoder.Encode(batchResult{Index: batch.Index, Output: "done\n"})
+	}()
+	done := make(chan error)
+	go func() {
+		done <- c.wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the busy worker was dropped")
+	}
+	if output.String() != "done\n" {
+		t.Errorf("expected done, got %q", output.String())
+	}
+}
+
+func TestInputSlice(t *testing.T) {
+	if _, err := parseSlice("2/2", ""); err == nil {
+		t.Error("shard 2/2 was accepted")
+	}
+	s, err := parseSlice("0/2", "2:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := os.ReadFile("testBouncers.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(text))
+	//indices 2, 4 and 6
+	expected := []string{lines[2], lines[4], lines[6]}
+	readAll := func(r *bufio.Reader) []byte {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	if got := strings.Fields(string(readAll(sliceInput(bufio.NewReader(bytes.NewReader(text)), s, "5x2")))); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected lines %v, got %v", expected, got)
+	}
+
+	//the header of the bbchallenge database doesn't count
+	records := bytes.Repeat([]byte{0, 0xDA}, 15)
+	for _, line := range lines {
+		records = append(records, bbchallengeRecord(parseTM(line))...)
+	}
+	sliced, err := machineInput(sliceInput(bufio.NewReader(bytes.NewReader(records)), s, "5x2"), "5x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(readAll(sliced))); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected records %v, got %v", expected, got)
+	}
+
+	short, err := os.ReadFile("testShortCert.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encoded bytes.Buffer
+	encodeCerts(bufio.NewReader(bytes.NewReader(short)), &encoded)
+	cr, err := newCertReader(sliceInput(bufio.NewReader(&encoded), s, "5x2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortLines := strings.Split(strings.TrimSpace(string(short)), "\n")
+	for _, index := range []int{2, 4, 6} {
+		cert, err := cr.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := shortCert{}
+		if err := json.Unmarshal([]byte(shortLines[index]), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if cert.(shortCert).Tm.String() != expected.Tm.String() {
+			t.Errorf("expected certificate %d for %v, got %v", index, expected.Tm, cert.(shortCert).Tm)
+		}
+	}
+	if _, err := cr.next(); err != io.EOF {
+		t.Error("expected only 3 certificates", err)
+	}
+}
+
+func TestInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	text, err := os.ReadFile("testBouncers.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(text))
+	//the first file doesn't end with a line break, the second one is compressed
+	plain := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(plain, []byte(strings.Join(lines[:4], "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	fmt.Fprintln(gz, strings.Join(lines[4:], "\n"))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	packed := filepath.Join(dir, "packed.gz")
+	if err := os.WriteFile(packed, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.ReadAll(inputFiles([]string{plain, packed}, "5x2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSpace(string(read)), "\n"); !reflect.DeepEqual(got, lines) {
+		t.Errorf("expected %v, got %v", lines, got)
+	}
+	if _, err := io.ReadAll(inputFiles([]string{filepath.Join(dir, "missing.txt")}, "5x2")); err == nil {
+		t.Error("a missing file was read")
+	}
+
+	//every bbchallenge file starts with the header of the database
+	paths := []string{}
+	for i, part := range [][]string{lines[:4], lines[4:]} {
+		records := bytes.Repeat([]byte{0, 0xDA}, 15)
+		for _, m := range part {
+			records = append(records, bbchallengeRecord(parseTM(m))...)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("records%d", i))
+		if err := os.WriteFile(path, records, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	read, err = io.ReadAll(inputFiles(paths, "5x2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 30*(len(lines)+1) {
+		t.Errorf("expected one header and %d records, got %d bytes", len(lines), len(read))
+	}
+	machines, err := machineInput(bufio.NewReader(bytes.NewReader(read)), "5x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err = io.ReadAll(machines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSpace(string(read)), "\n"); !reflect.DeepEqual(got, lines) {
+		t.Errorf("expected %v, got %v", lines, got)
+	}
+
+	output := filepath.Join(dir, "output.gz")
+	w, closeOutput, err := openOutput(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(w, lines[0])
+	if err := closeOutput(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decompressed, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(decompressed); err != nil || string(got) != lines[0]+"\n" {
+		t.Errorf("expected %s in the output, got %q %v", lines[0], got, err)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//scanSettings are the options of the decider, the coordinator sends them with every batch so all workers agree
+type scanSettings struct {
+	Deciders  []string
+	StepLimit int
+	Exact     bool
+	MaxSpacing int
+	PrintMode int
+	Tnf       bool
+	TnfMirror bool
+}
+
+//batchRequest is sent by the coordinator, one JSON object per line like all messages
+type batchRequest struct {
+	Index     int
+	Settings  scanSettings
+	Machines  []string
+	Keepalive time.Duration //how often the worker tells that it is still working on the batch
+}
+
+//batchResult is the output of the decider for a batch, the same as runScan would print for the machines in order.
+//While the worker is busy it sends results with only Working set.
+type batchResult struct {
+	Index   int
+	Output  string
+	Working bool `json:",omitempty"`
+}
+
+//coordinator hands out the batches of the input and writes their output in the order of the input
+type coordinator struct {
+	mutex       sync.Mutex
+	changed     *sync.Cond
+	pending     []*batchRequest
+	inputDone   bool
+	outstanding int //batches that were read but whose output isn't written yet
+	results     map[int]string
+	nextOutput  int
+	output      io.Writer
+	timeout     time.Duration //for a worker to take a batch and to send its output or that it is still working
+	err         error
+}
+
+//how many batches the coordinator reads ahead of the workers
+const pendingBatches = 64
+
+func newCoordinator(output io.Writer, timeout time.Duration) *coordinator {
+	c := &coordinator{results: map[int]string{}, output: output, timeout: timeout}
+	c.changed = sync.NewCond(&c.mutex)
+	return c
+}
+
+func (c *coordinator) add(batch *batchRequest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.pending) >= pendingBatches {
+		c.changed.Wait()
+	}
+	c.pending = append(c.pending, batch)
+	c.outstanding++
+	c.changed.Broadcast()
+}
+
+//next waits for a batch to hand out and returns nil once every batch is done
+func (c *coordinator) next() *batchRequest {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.pending) == 0 && !(c.inputDone && c.outstanding == 0) {
+		c.changed.Wait()
+	}
+	if len(c.pending) == 0 {
+		return nil
+	}
+	batch := c.pending[0]
+	c.pending = c.pending[1:]
+	c.changed.Broadcast()
+	return batch
+}
+
+//reassign puts the batch of a worker that disconnected or timed out in front of the others
+func (c *coordinator) reassign(batch *batchRequest) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending = append([]*batchRequest{batch}, c.pending...)
+	c.changed.Broadcast()
+}
+
+//finish writes the output of every batch that is complete up to the first one that is missing
+func (c *coordinator) finish(result batchResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, done := c.results[result.Index]; done || result.Index < c.nextOutput {
+		return
+	}
+	c.results[result.Index] = result.Output
+	c.outstanding--
+	for {
+		output, ok := c.results[c.nextOutput]
+		if !ok {
+			break
+		}
+		if _, err := io.WriteString(c.output, output); err != nil && c.err == nil {
+			c.err = err
+		}
+		delete(c.results, c.nextOutput)
+		c.nextOutput++
+	}
+	c.changed.Broadcast()
+}
+
+func (c *coordinator) closeInput() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inputDone = true
+	c.changed.Broadcast()
+}
+
+//wait returns once the output of every batch is written
+func (c *coordinator) wait() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for !(c.inputDone && c.outstanding == 0) {
+		c.changed.Wait()
+	}
+	return c.err
+}
+
+//serveWorker hands batches to one worker until the input is done or the worker disconnects.
+//A worker that stays silent for longer than the timeout is disconnected, a busy one sends keepalives.
+func (c *coordinator) serveWorker(conn net.Conn) {
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		batch := c.next()
+		if batch == nil {
+			return
+		}
+		result := batchResult{Working: true}
+		err := conn.SetDeadline(time.Now().Add(c.timeout))
+		if err == nil {
+			err = encoder.Encode(batch)
+		}
+		for err == nil && result.Working {
+			result = batchResult{}
+			if err = decoder.Decode(&result); err == nil {
+				err = conn.SetDeadline(time.Now().Add(c.timeout))
+			}
+		}
+		if err == nil && result.Index != batch.Index {
+			err = fmt.Errorf("Expected the result of batch %d, got %d", batch.Index, result.Index)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Lost worker %s, batch %d is reassigned: %s\n", conn.RemoteAddr(), batch.Index, err)
+			c.reassign(batch)
+			return
+		}
+		c.finish(result)
+	}
+}
+
+//runCoordinator reads the machines from the input in batches of batchSize, hands them to the workers that connect
+//to the listener and writes their output to output in the order of the input. Batches of workers that disconnect
+//or are silent for longer than timeout are handed to the next worker. It returns once every batch is done, workers are disconnected then.
+func runCoordinator(listener net.Listener, input *bufio.Reader, output io.Writer, settings scanSettings, batchSize int, timeout time.Duration) error {
+	c := newCoordinator(output, timeout)
+	conns := map[net.Conn]bool{}
+	var connMutex sync.Mutex
+	acceptDone := make(chan struct{})
+	go func() {
+		defer close(acceptDone)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connMutex.Lock()
+			conns[conn] = true
+			connMutex.Unlock()
+			go c.serveWorker(conn)
+		}
+	}()
+
+	var readerErr error
+	//a keepalive can be late without the worker being dropped
+	keepalive := timeout / 3
+	batch := &batchRequest{Settings: settings, Keepalive: keepalive}
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		if text = strings.TrimSpace(text); text != "" {
+			batch.Machines = append(batch.Machines, text)
+		}
+		if len(batch.Machines) == batchSize || (readerErr != nil && len(batch.Machines) > 0) {
+			c.add(batch)
+			batch = &batchRequest{Index: batch.Index + 1, Settings: settings, Keepalive: keepalive}
+		}
+	}
+	c.closeInput()
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+	err := c.wait()
+
+	//no connection is added once the accept loop is done
+	listener.Close()
+	<-acceptDone
+	connMutex.Lock()
+	for conn := range conns {
+		conn.Close()
+	}
+	connMutex.Unlock()
+	return err
+}
+
+//runWorker connects to a coordinator and runs the decider on the batches it gets until the coordinator disconnects
+func runWorker(addr string, workTokens chan struct{}) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	var encoderMutex sync.Mutex
+	send := func(result batchResult) error {
+		encoderMutex.Lock()
+		defer encoderMutex.Unlock()
+		return encoder.Encode(result)
+	}
+	for {
+		batch := batchRequest{}
+		if err := decoder.Decode(&batch); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		settings := batch.Settings
+		chain, err := newChain(settings.Deciders, settings.Exact, settings.MaxSpacing)
+		if err != nil {
+			return err
+		}
+		done := make(chan struct{})
+		if batch.Keepalive > 0 {
+			go func() {
+				ticker := time.NewTicker(batch.Keepalive)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+						//a failed keepalive shows up when the output is sent
+						_ = send(batchResult{Index: batch.Index, Working: true})
+					}
+				}
+			}()
+		}
+		outputs := make([]string, len(batch.Machines))
+		var wg sync.WaitGroup
+		for i, text := range batch.Machines {
+			i, text := i, text
+			_ = <-workTokens
+			wg.Add(1)
+			go func() {
+				defer func() {
+					workTokens <- struct{}{}
+					if err := recover(); err != nil {
+						fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
+					}
+					wg.Done()
+				}()
+				var b strings.Builder
+				scanMachine(&b, text, chain, settings.StepLimit, settings.PrintMode, settings.Tnf, settings.TnfMirror)
+				outputs[i] = b.String()
+			}()
+		}
+		wg.Wait()
+		close(done)
+		if err := send(batchResult{Index: batch.Index, Output: strings.Join(outputs, "")}); err != nil {
+			return err
+		}
+	}
+}
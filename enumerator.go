@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//enumerateTNF generates the machines in Tree Normal Form with the given number of states and symbols
+//that neither halt nor run off into the blank part of the tape within stepLimit steps.
+//
+//Starting from 1RB in A0 every machine is simulated until it reaches an undefined transition. Then a child is
+//created for every way to define it, where only one new state and one new symbol can be used at a time.
+//A machine that reaches its last undefined transition halts, so it is left out.
+func enumerateTNF(numStates int, numSymbols int, stepLimit int, emit func(turingMachine)) {
+	if numStates < 2 || numSymbols < 2 {
+		return
+	}
+	tm := turingMachine{
+		numStates:   numStates,
+		numSymbols:  numSymbols,
+		transitions: map[headConfig]transition{{A, 0}: {1, R, B}},
+	}
+	expandTNF(tm, B, 1, stepLimit, emit)
+}
+
+func expandTNF(tm turingMachine, maxState tmState, maxSymbol baseSymbol, stepLimit int, emit func(turingMachine)) {
+	hc, result := simulateTNF(tm, stepLimit)
+	switch result {
+	case tnfUndecided:
+		emit(tm)
+		return
+	case tnfRunaway:
+		return
+	}
+	if len(tm.transitions) == tm.numStates*tm.numSymbols-1 {
+		return
+	}
+	newMaxState, newMaxSymbol := maxState, maxSymbol
+	if int(maxState) < tm.numStates-1 {
+		newMaxState++
+	}
+	if int(maxSymbol) < tm.numSymbols-1 {
+		newMaxSymbol++
+	}
+	for sy := baseSymbol(0); sy <= newMaxSymbol; sy++ {
+		for _, dir := range []direction{R, L} {
+			for state := A; state <= newMaxState; state++ {
+				child := turingMachine{
+					numStates:   tm.numStates,
+					numSymbols:  tm.numSymbols,
+					transitions: map[headConfig]transition{},
+				}
+				for k, v := range tm.transitions {
+					child.transitions[k] = v
+				}
+				child.transitions[hc] = transition{sy, dir, state}
+				childMaxState, childMaxSymbol := maxState, maxSymbol
+				if state > childMaxState {
+					childMaxState = state
+				}
+				if sy > childMaxSymbol {
+					childMaxSymbol = sy
+				}
+				expandTNF(child, childMaxState, childMaxSymbol, stepLimit, emit)
+			}
+		}
+	}
+}
+
+const (
+	tnfHalted = iota
+	tnfUndecided
+	tnfRunaway
+)
+
+//simulateTNF runs the tm from a blank tape and returns the undefined transition it reaches.
+//A tm that reads a blank at the edge of the visited tape and moves further out without changing its state never comes back.
+func simulateTNF(tm turingMachine, stepLimit int) (headConfig, int) {
+	tape := map[int]baseSymbol{}
+	pos, minPos, maxPos := 0, 0, 0
+	headCon := headConfig{}
+	for steps := 0; steps < stepLimit; steps++ {
+		tr, ok := tm.transitions[headCon]
+		if !ok {
+			return headCon, tnfHalted
+		}
+		if headCon.symbol == 0 && tr.state == headCon.state &&
+			(pos == maxPos && tr.direction == R || pos == minPos && tr.direction == L) {
+			return headCon, tnfRunaway
+		}
+		tape[pos] = tr.symbol
+		switch tr.direction {
+		case L:
+			pos--
+		case R:
+			pos++
+		}
+		if pos < minPos {
+			minPos = pos
+		}
+		if pos > maxPos {
+			maxPos = pos
+		}
+		headCon = headConfig{tr.state, tape[pos]}
+	}
+	return headCon, tnfUndecided
+}
+
+//bbchallengeRecord encodes a tm like the bbchallenge database: 3 bytes per transition for
+//the symbol, the direction (0 for R, 1 for L) and the next state starting at 1, all 0 for an undefined transition
+func bbchallengeRecord(tm turingMachine) []byte {
+	record := make([]byte, 0, 3*tm.numStates*tm.numSymbols)
+	for state := 0; state < tm.numStates; state++ {
+		for sy := 0; sy < tm.numSymbols; sy++ {
+			tr, ok := tm.transitions[headConfig{tmState(state), baseSymbol(sy)}]
+			if !ok {
+				record = append(record, 0, 0, 0)
+				continue
+			}
+			move := byte(0)
+			if tr.direction == L {
+				move = 1
+			}
+			record = append(record, byte(tr.symbol), move, byte(tr.state)+1)
+		}
+	}
+	return record
+}
+
+//parseBbchallengeRecord decodes a record written by bbchallengeRecord and fails for bytes that can't be one
+func parseBbchallengeRecord(record []byte, numStates int, numSymbols int) (turingMachine, bool) {
+	if len(record) != 3*numStates*numSymbols {
+		return turingMachine{}, false
+	}
+	tm := turingMachine{
+		numStates:   numStates,
+		numSymbols:  numSymbols,
+		transitions: map[headConfig]transition{},
+	}
+	for i := 0; i < len(record); i += 3 {
+		symbol, move, next := record[i], record[i+1], record[i+2]
+		if next == 0 {
+			if symbol != 0 || move != 0 {
+				return turingMachine{}, false
+			}
+			continue
+		}
+		if int(symbol) >= numSymbols || move > 1 || int(next) > numStates {
+			return turingMachine{}, false
+		}
+		dir := R
+		if move == 1 {
+			dir = L
+		}
+		config := headConfig{tmState(i / 3 / numSymbols), baseSymbol(i / 3 % numSymbols)}
+		tm.transitions[config] = transition{baseSymbol(symbol), dir, tmState(next - 1)}
+	}
+	return tm, true
+}
+
+//isBbchallenge tells whether the input consists of bbchallenge records without consuming anything.
+//Text starts with a printable character and binary certificates with their magic.
+func isBbchallenge(input *bufio.Reader) bool {
+	head, err := input.Peek(1)
+	return err == nil && head[0] < '\t' && !isBinary(input)
+}
+
+//bbchallengeLines turns bbchallenge records into machines in standard text format, one per line.
+//The bbchallenge database starts with a header of the size of a record that is no valid record, so an invalid first record is skipped.
+func bbchallengeLines(input *bufio.Reader, numStates int, numSymbols int) *bufio.Reader {
+	r, w := io.Pipe()
+	go func() {
+		output := bufio.NewWriter(w)
+		record := make([]byte, 3*numStates*numSymbols)
+		var err error
+		for i := 0; err == nil; i++ {
+			if _, err = io.ReadFull(input, record); err != nil {
+				break
+			}
+			tm, ok := parseBbchallengeRecord(record, numStates, numSymbols)
+			if !ok {
+				if i > 0 {
+					fmt.Fprintf(os.Stderr, "Unable to parse bbchallenge record %d\n", i)
+				}
+				continue
+			}
+			_, err = fmt.Fprintln(output, tm)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if flushErr := output.Flush(); err == nil {
+			err = flushErr
+		}
+		w.CloseWithError(err)
+	}()
+	return bufio.NewReader(r)
+}
+
+//parseClass reads the size of the machines for -enum like 3x3 for 3 states and 3 symbols
+func parseClass(class string) (int, int, error) {
+	parts := strings.Split(class, "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Unable to parse %s, expected states x symbols like 3x3", class)
+	}
+	numStates, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	numSymbols, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if numStates < 2 || numStates > 26 || numSymbols < 2 || numSymbols > 10 {
+		return 0, 0, fmt.Errorf("%s is no supported size, 2 to 26 states and 2 to 10 symbols are possible", class)
+	}
+	return numStates, numSymbols, nil
+}
+
+//writeEnumeration streams the machines in standard text format, one per line, or as bbchallenge records
+func writeEnumeration(output io.Writer, numStates int, numSymbols int, stepLimit int, binary bool) error {
+	w := bufio.NewWriter(output)
+	var writeErr error
+	enumerateTNF(numStates, numSymbols, stepLimit, func(tm turingMachine) {
+		if writeErr != nil {
+			return
+		}
+		if binary {
+			_, writeErr = w.Write(bbchallengeRecord(tm))
+		} else {
+			_, writeErr = fmt.Fprintln(w, tm)
+		}
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return w.Flush()
+}
+
+//enumerateAndScan feeds the enumeration into runScan as if it was the input
+func enumerateAndScan(numStates int, numSymbols int, enumSteps int, scan func(input *bufio.Reader)) {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeEnumeration(w, numStates, numSymbols, enumSteps, false))
+	}()
+	scan(bufio.NewReader(r))
+	if err := r.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
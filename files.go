@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+//gzipFile closes the decompressor together with the file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f gzipFile) Close() error {
+	err := f.Reader.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//openInput opens a file of the input and decompresses it if it starts like gzip, whatever its name is
+func openInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	head, _ := buffered.Peek(2)
+	if len(head) < 2 || head[0] != 0x1f || head[1] != 0x8b {
+		return struct {
+			io.Reader
+			io.Closer
+		}{buffered, file}, nil
+	}
+	decompressed, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return gzipFile{decompressed, file}, nil
+}
+
+//lastByteWriter remembers the last byte written through it
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (lw *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		lw.last = p[len(p)-1]
+	}
+	return lw.w.Write(p)
+}
+
+//inputFiles reads the files one after another as a single input. A text file that doesn't end with a line break
+//gets one, binary certificate files after the first lose their magic and bbchallenge files after the first
+//their header, so they read like one file. bbcSize is the size of the machines in bbchallenge records.
+func inputFiles(paths []string, bbcSize string) *bufio.Reader {
+	r, w := io.Pipe()
+	go func() {
+		var err error
+		for i, path := range paths {
+			if err = copyInput(w, path, i == 0, bbcSize); err != nil {
+				break
+			}
+		}
+		w.CloseWithError(err)
+	}()
+	return bufio.NewReader(r)
+}
+
+func copyInput(w io.Writer, path string, first bool, bbcSize string) error {
+	file, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	input := bufio.NewReader(file)
+	binaryInput := isBinary(input) || isBbchallenge(input)
+	if isBinary(input) && !first {
+		if _, err := input.Discard(len(binaryMagic)); err != nil {
+			return err
+		}
+	}
+	if isBbchallenge(input) && !first {
+		if err := skipBbchallengeHeader(input, bbcSize); err != nil {
+			return err
+		}
+	}
+	lw := &lastByteWriter{w: w, last: '\n'}
+	if _, err := io.Copy(lw, input); err != nil {
+		return err
+	}
+	if !binaryInput && lw.last != '\n' {
+		_, err = io.WriteString(w, "\n")
+	}
+	return err
+}
+
+//skipBbchallengeHeader drops the first record if it is no valid record, like bbchallengeLines does for the first file
+func skipBbchallengeHeader(input *bufio.Reader, bbcSize string) error {
+	numStates, numSymbols, err := parseClass(bbcSize)
+	if err != nil {
+		return err
+	}
+	size := 3 * numStates * numSymbols
+	head, _ := input.Peek(size)
+	if len(head) < size {
+		return nil
+	}
+	if _, ok := parseBbchallengeRecord(head, numStates, numSymbols); ok {
+		return nil
+	}
+	_, err = input.Discard(size)
+	return err
+}
+
+//syncWriter lets the goroutines of a mode write to a writer that isn't safe for concurrent use
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	return sw.w.Write(p)
+}
+
+//openOutput creates the file at path for the output of a mode, compressed with gzip if the path ends with .gz.
+//The returned function closes the file once everything is written.
+func openOutput(path string) (io.Writer, func() error, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, file.Close, nil
+	}
+	compressed := gzip.NewWriter(file)
+	return &syncWriter{w: compressed}, func() error {
+		err := compressed.Close()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
@@ -6,20 +6,89 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 )
 
 func main() {
-	fullCert := flag.Bool("fc", false, "checks certificates instead of running the decider")
+	fullCert := flag.Bool("fc", false, "checks certificates of any format instead of running the decider")
 	shortCert := flag.Bool("sc", false, "checks certificates instead of running the decider")
 	stepLimit := flag.Int("n", 10000, "scans with this stepLimit")
 	exact := flag.Bool("x", false, "only tests for records at steplimit, for use with filtered input")
+	maxSpacing := flag.Int("p", 0, "maximum spacing between the records the bouncer decider compares, 0 for no limit")
 	printMode := flag.Int("pm", 0, "what to print: 0 -> solved TMs, 1 -> certificates, 2 -> certificates with indent")
+	minimize := flag.Bool("min", false, "with -fc or -sc prints the canonical minimal version of each valid certificate")
+	toFull := flag.Bool("tofull", false, "converts short certificates to full certificates without verifying them")
+	toShort := flag.Bool("toshort", false, "converts full certificates to short certificates without verifying them")
+	encode := flag.Bool("encode", false, "converts JSON certificates to the binary format")
+	decode := flag.Bool("decode", false, "converts binary certificates to JSON")
+	archivePath := flag.String("archive", "", "certificate archive for -add, -lookup, -verifyall and -export")
+	add := flag.Bool("add", false, "adds the certificates from the input to the archive")
+	lookup := flag.String("lookup", "", "prints the certificates in the archive for a tm or bbchallenge id")
+	verifyAll := flag.Bool("verifyall", false, "verifies every certificate in the archive and prints the invalid ones")
+	export := flag.Bool("export", false, "prints the certificates in the archive, with -keys only those for the machines in that file")
+	keysPath := flag.String("keys", "", "file with one tm or bbchallenge id per line for -export")
+	schema := flag.Bool("schema", false, "prints the JSON Schema of the certificates")
+	tnf := flag.Bool("tnf", false, "normalizes the machines to Tree Normal Form before deciding them, the certificates record the mapping")
+	tnfMirror := flag.Bool("tnfmirror", false, "with -tnf also mirrors machines whose first move goes to the left")
+	original := flag.Bool("original", false, "with -fc or -sc transports certificates for normalized machines back to the original machine")
+	transport := flag.String("transport", "", "turns the certificates from the input into verified certificates for this isomorphic tm")
+	enum := flag.String("enum", "", "enumerates the machines in Tree Normal Form of a size like 3x3 (states x symbols) instead of reading them")
+	enumSteps := flag.Int("enumsteps", 1000, "with -enum leaves out machines that halt within this many steps")
+	enumBinary := flag.Bool("enumbbc", false, "with -enum writes bbchallenge binary records instead of text")
+	scan := flag.Bool("scan", false, "with -enum runs the decider on the enumerated machines instead of printing them")
+	deciderChain := flag.String("deciders", "bouncer", "comma separated deciders to try in order, each tm is printed by the first one that decides it: "+strings.Join(deciderNames(), ", "))
+	render := flag.String("render", "", "draws the space-time diagram of the first tm or certificate from the input into this file, SVG for .svg and PNG otherwise")
+	renderSteps := flag.Int("rendersteps", 1000, "with -render the number of steps to draw")
+	renderScale := flag.Int("renderscale", 2, "with -render the size of a cell in pixels")
+	serveAddr := flag.String("serve", "", "runs a verification service on this address like localhost:8080, certificates are POSTed to /verify")
+	coordinatorAddr := flag.String("coordinator", "", "listens on this address like localhost:9000 for workers, hands them the tms from the input in batches and prints their output in order")
+	workerAddr := flag.String("worker", "", "connects to the coordinator at this address and runs the decider with its options on the batches it hands out")
+	batchSize := flag.Int("batch", 100, "with -coordinator the number of tms per batch")
+	batchTimeout := flag.Duration("batchtimeout", 30*time.Minute, "with -coordinator the time a worker may be silent before its batch is handed to another worker")
+	bbcSize := flag.String("bbcsize", "5x2", "the size of the machines in bbchallenge binary input, which is recognized by its first byte")
+	shard := flag.String("shard", "", "only reads the lines or records of the input whose index from 0 is i modulo N, like 2/8")
+	inputRange := flag.String("range", "", "only reads the lines or records of the input from index start up to before end, like 1000:2000, either bound can be left out")
+	outputPath := flag.String("o", "", "writes the output to this file instead of stdout, compressed with gzip if it ends with .gz")
+	trace := flag.Bool("trace", false, "prints every record quadruple the bouncer decider examines at stepLimit with the first stage that failed for it")
+	reportDir := flag.String("report", "", "sorts the tms from the input that the bouncer decider doesn't decide into one file per deepest stage of -trace in this directory")
+	colorDir := flag.String("colordump", "", "writes the colored tapes and words of every record quadruple the decider examines for the tms from the input into this directory")
 	cores := flag.Int("cores", 0, "maximum number of TMs to work on in parallel")
 
-	flag.Parse()
+	//show and step are subcommands, their flags follow them
+	args := os.Args[1:]
+	subcommand := ""
+	if len(args) > 0 && (args[0] == "show" || args[0] == "step") {
+		subcommand = args[0]
+		args = args[1:]
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return
+	}
+
+	chain, err := newChain(strings.Split(*deciderChain, ","), *exact, *maxSpacing)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	var output io.Writer = os.Stdout
+	if *outputPath != "" {
+		var closeOutput func() error
+		output, closeOutput, err = openOutput(*outputPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		defer func() {
+			if err := closeOutput(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
 
 	if *cores <= 0 {
 		*cores = runtime.GOMAXPROCS(0)
@@ -28,15 +97,114 @@ func main() {
 	for i := 0; i < *cores; i++ {
 		workTokens <- struct{}{}
 	}
+	slice, err := parseSlice(*shard, *inputRange)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
 	input := bufio.NewReader(os.Stdin) //a Scanner would be more convenient, but the strings for some full certificates are too long
+	//the arguments of step are its own, otherwise they are input files
+	if subcommand != "step" && flag.NArg() > 0 {
+		input = inputFiles(flag.Args(), *bbcSize)
+	}
+	input = sliceInput(input, slice, *bbcSize)
 
 	switch {
+	case *archivePath != "":
+		runArchive(*archivePath, input, output, workTokens, *add, *lookup, *verifyAll, *export, *keysPath)
+	case *schema:
+		printSchema(output)
+	case *serveAddr != "":
+		if err := serve(*serveAddr, workTokens); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case *coordinatorAddr != "":
+		input, err := machineInput(input, *bbcSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		listener, err := net.Listen("tcp", *coordinatorAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		settings := scanSettings{strings.Split(*deciderChain, ","), *stepLimit, *exact, *maxSpacing, *printMode, *tnf, *tnfMirror}
+		if err := runCoordinator(listener, input, output, settings, *batchSize, *batchTimeout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case *workerAddr != "":
+		if err := runWorker(*workerAddr, workTokens); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case subcommand == "show":
+		runShow(input, output, *stepLimit)
+	case subcommand == "step":
+		tm, cert, err := loadStepper(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		runStepper(tm, cert, input, output)
+	case *trace:
+		runTrace(input, output, workTokens, *stepLimit, *maxSpacing)
+	case *reportDir != "":
+		if err := runReport(input, output, workTokens, *reportDir, *stepLimit, *exact, *maxSpacing); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case *colorDir != "":
+		if err := dumpColors(input, *colorDir, *stepLimit, *maxSpacing); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case *render != "":
+		if err := renderInput(input, *render, *renderSteps, *renderScale); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	case *encode:
+		encodeCerts(input, output)
+	case *decode:
+		decodeCerts(input, output)
+	case *transport != "":
+		to := parseTM(*transport)
+		if to.numStates == 0 {
+			fmt.Fprintf(os.Stderr, "Unable to parse %s\n", *transport)
+			return
+		}
+		transportCerts(input, output, workTokens, to, *printMode)
+	case *enum != "":
+		numStates, numSymbols, err := parseClass(*enum)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if !*scan {
+			if err := writeEnumeration(output, numStates, numSymbols, *enumSteps, *enumBinary); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
+		}
+		enumerateAndScan(numStates, numSymbols, *enumSteps, func(input *bufio.Reader) {
+			runScan(sliceInput(input, slice, *bbcSize), output, workTokens, chain, *stepLimit, *printMode, *tnf, *tnfMirror)
+		})
+	case *toFull:
+		convertCerts(input, output, workTokens, true)
+	case *toShort:
+		convertCerts(input, output, workTokens, false)
+	case *fullCert && isBinary(input):
+		checkBinaryCerts(input, output, workTokens, fullFormat, *printMode, *minimize, *original)
 	case *fullCert:
-		checkFullCerts(input, workTokens, *printMode)
+		checkFullCerts(input, output, workTokens, *printMode, *minimize, *original)
+	case *shortCert && isBinary(input):
+		checkBinaryCerts(input, output, workTokens, shortFormat, *printMode, *minimize, *original)
 	case *shortCert:
-		checkShortCerts(input, workTokens, *printMode)
+		checkShortCerts(input, output, workTokens, *printMode, *minimize, *original)
 	default:
-		runScan(input, workTokens, *stepLimit, *exact, *printMode)
+		input, err := machineInput(input, *bbcSize)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		runScan(input, output, workTokens, chain, *stepLimit, *printMode, *tnf, *tnfMirror)
 	}
 
 	//make sure all the work is finished
@@ -45,7 +213,19 @@ func main() {
 	}
 }
 
-func checkFullCerts(input *bufio.Reader, workTokens chan struct{}, printMode int) {
+//machineInput converts bbchallenge records in the input to tms in standard text format
+func machineInput(input *bufio.Reader, bbcSize string) (*bufio.Reader, error) {
+	if !isBbchallenge(input) {
+		return input, nil
+	}
+	numStates, numSymbols, err := parseClass(bbcSize)
+	if err != nil {
+		return nil, err
+	}
+	return bbchallengeLines(input, numStates, numSymbols), nil
+}
+
+func checkFullCerts(input *bufio.Reader, output io.Writer, workTokens chan struct{}, printMode int, minimize bool, original bool) {
 	var readerErr error
 	for readerErr == nil {
 		var text string
@@ -62,13 +242,38 @@ func checkFullCerts(input *bufio.Reader, workTokens chan struct{}, printMode int
 					fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
 				}
 			}()
-			cert := fullCert{}
-			err := json.Unmarshal([]byte(text), &cert)
+			v, err := findVerifier([]byte(text))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
+				return
+			}
+			cert, ok, err := v.verify([]byte(text))
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
 				return
 			}
-			verifyBouncer(cert, printMode)
+			if !ok {
+				return
+			}
+			//minimizing only applies to bouncers, transported certificates are verified again
+			switch other := cert.(type) {
+			case fullCert:
+				if minimize || original {
+					verifyCert(output, other, printMode, minimize, original)
+					return
+				}
+			case cyclerCert:
+				if original {
+					cert, ok = other.original(), verifyCycler(other.original())
+				}
+			case translatedCyclerCert:
+				if original {
+					cert, ok = other.original(), verifyTranslatedCycler(other.original())
+				}
+			}
+			if ok {
+				cert.print(output, printMode)
+			}
 		}()
 	}
 	if readerErr != io.EOF {
@@ -76,7 +281,7 @@ func checkFullCerts(input *bufio.Reader, workTokens chan struct{}, printMode int
 	}
 }
 
-func checkShortCerts(input *bufio.Reader, workTokens chan struct{}, printMode int) {
+func checkShortCerts(input *bufio.Reader, output io.Writer, workTokens chan struct{}, printMode int, minimize bool, original bool) {
 	var readerErr error
 	for readerErr == nil {
 		var text string
@@ -99,15 +304,12 @@ func checkShortCerts(input *bufio.Reader, workTokens chan struct{}, printMode in
 				fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
 				return
 			}
-			tm := cert.Tm
-			if cert.Mirror {
-				tm = tm.mirror()
-			}
-			rules := findRules(tm, cert.Start, cert.CycleSteps)
-			if rules == nil {
+			full, err := expandShortCert(cert)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to expand %s\n%s\n", text, err)
 				return
 			}
-			verifyBouncer(fullCert{cert.Tm, cert.Mirror, cert.Start, rules}, printMode)
+			verifyCert(output, full, printMode, minimize, original)
 		}()
 	}
 	if readerErr != io.EOF {
@@ -115,7 +317,57 @@ func checkShortCerts(input *bufio.Reader, workTokens chan struct{}, printMode in
 	}
 }
 
-func runScan(input *bufio.Reader, workTokens chan struct{}, stepLimit int, exact bool, printMode int) {
+func runArchive(path string, input *bufio.Reader, output io.Writer, workTokens chan struct{}, add bool, lookup string, verifyAll bool, export bool, keysPath string) {
+	a, err := openArchive(path, add)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer func() {
+		if err := a.close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	switch {
+	case add:
+		addToArchive(a, input)
+	case lookup != "":
+		exportArchive(a, output, []string{lookup})
+	case verifyAll:
+		verifyArchive(a, output, workTokens)
+	case export && keysPath != "":
+		text, err := os.ReadFile(keysPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		keys := []string{}
+		for _, key := range strings.Split(string(text), "\n") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		exportArchive(a, output, keys)
+	case export:
+		exportArchive(a, output, nil)
+	default:
+		fmt.Fprintln(os.Stderr, "-archive needs one of -add, -lookup, -verifyall or -export")
+	}
+}
+
+func scanTM(tm turingMachine, stepLimit int, exact bool, maxSpacing int) (fullCert, bool) {
+	if !exact {
+		for n := 100; n < stepLimit; n *= 10 {
+			if cert, ok := findBouncer(tm, n, maxSpacing); ok {
+				return cert, true
+			}
+		}
+	}
+	return findBouncer(tm, stepLimit, maxSpacing)
+}
+
+//transportCerts reads full and short certificates and prints them for an isomorphic tm
+func transportCerts(input *bufio.Reader, output io.Writer, workTokens chan struct{}, to turingMachine, printMode int) {
 	var readerErr error
 	for readerErr == nil {
 		var text string
@@ -132,22 +384,152 @@ func runScan(input *bufio.Reader, workTokens chan struct{}, stepLimit int, exact
 					fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
 				}
 			}()
-			tm := parseTM(text)
-			if tm.numStates == 0 {
-				fmt.Fprintf(os.Stderr, "Unable to parse %s\n", text)
+			_, record, err := parseCertLine(text)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
+				return
+			}
+			cert, err := decodeCert(record)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
+				return
+			}
+			var full fullCert
+			switch cert := cert.(type) {
+			case fullCert:
+				full = cert
+			case shortCert:
+				full, err = expandShortCert(cert)
+			}
+			if err == nil {
+				full, err = transportCert(full, to)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to transport %s\n%s\n", text, err)
 				return
 			}
-			if !exact {
-				for n := 100; n < stepLimit; n *= 10 {
-					if decideBouncers(tm, n, printMode) {
-						return
-					}
+			fprintCert(output, full, printMode)
+		}()
+	}
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+}
+
+//convertCerts prints every certificate in the other format and reports those that can't be converted
+func convertCerts(input *bufio.Reader, output io.Writer, workTokens chan struct{}, toFull bool) {
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		if text == "" {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		_ = <-workTokens
+		go func() {
+			defer func() {
+				workTokens <- struct{}{}
+				if err := recover(); err != nil {
+					fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
+				}
+			}()
+			var converted interface{}
+			var err error
+			if toFull {
+				cert := shortCert{}
+				if err = json.Unmarshal([]byte(text), &cert); err == nil {
+					converted, err = expandShortCert(cert)
+				}
+			} else {
+				cert := fullCert{}
+				if err = json.Unmarshal([]byte(text), &cert); err == nil {
+					converted, err = shortenFullCert(cert)
 				}
 			}
-			decideBouncers(tm, stepLimit, printMode)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to convert %s\n%s\n", text, err)
+				return
+			}
+			b, err := json.Marshal(converted)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Fprintln(output, string(b))
 		}()
 	}
 	if readerErr != io.EOF {
 		fmt.Fprintln(os.Stderr, readerErr)
 	}
 }
+
+func verifyCert(output io.Writer, cert fullCert, printMode int, minimize bool, original bool) {
+	if original {
+		transported, err := cert.original()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to transport the certificate for %v\n%s\n", cert.Tm, err)
+			return
+		}
+		cert = transported
+	}
+	if !verifyBouncer(cert) {
+		return
+	}
+	if minimize {
+		cert = minimizeCert(cert)
+	}
+	fprintCert(output, cert, printMode)
+}
+
+func runScan(input *bufio.Reader, output io.Writer, workTokens chan struct{}, chain []decider, stepLimit int, printMode int, tnf bool, tnfMirror bool) {
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		if text == "" {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		_ = <-workTokens
+		go func() {
+			defer func() {
+				workTokens <- struct{}{}
+				if err := recover(); err != nil {
+					fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
+				}
+			}()
+			scanMachine(output, text, chain, stepLimit, printMode, tnf, tnfMirror)
+		}()
+	}
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+}
+
+//scanMachine runs the chain on one tm in standard text format and writes it or its certificate to w if it is decided
+func scanMachine(w io.Writer, text string, chain []decider, stepLimit int, printMode int, tnf bool, tnfMirror bool) {
+	tm := parseTM(text)
+	if tm.numStates == 0 {
+		fmt.Fprintf(os.Stderr, "Unable to parse %s\n", text)
+		return
+	}
+	original := tm
+	var mapping *tmMapping
+	if tnf {
+		var m tmMapping
+		tm, m = tm.normalize(tnfMirror)
+		mapping = &m
+	}
+	cert, ok := decideChain(chain, tm, stepLimit)
+	if !ok {
+		return
+	}
+	if mapping != nil {
+		cert = cert.withMapping(mapping)
+	}
+	if printMode == 0 {
+		fmt.Fprintln(w, original)
+		return
+	}
+	cert.print(w, printMode)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"reflect"
+)
+
+//minimizeCert looks for the canonical version of a verified certificate: a direct proof
+//without mirroring, primitive repeaters, the smallest buffer, walls without copies of the
+//repeaters next to them and the earliest start. Every change is only kept if the certificate
+//still verifies. Certificates with variants are only unmirrored. The mapping to the original tm is kept.
+func minimizeCert(cert fullCert) fullCert {
+	mapping := cert.Mapping
+	if cert.Mirror {
+		direct := cert.mirror()
+		direct.Tm = cert.Tm
+		direct.Mirror = false
+		if verifyBouncer(direct) {
+			cert = direct
+		}
+	}
+	if len(cert.Variants) == 0 {
+		cert = minimizeRepeaters(cert)
+		cert = minimizeBuffer(cert)
+		cert = minimizeWalls(cert)
+		cert = minimizeSteps(cert)
+		cert.Start = normalizeRepetitions(cert.Start)
+	}
+	cert.Mapping = mapping
+	return cert
+}
+
+//replaces every repeater u^k by u with k times the multiplier and offset
+func minimizeRepeaters(cert fullCert) fullCert {
+	for i := 1; i < len(cert.Start.Words); i += 2 {
+		root := primitiveRoot(cert.Start.Words[i])
+		k := len(cert.Start.Words[i]) / len(root)
+		if k == 1 {
+			continue
+		}
+		start := copyStart(cert.Start)
+		start.Words[i] = append(word{}, root...)
+		start.Multipliers[i/2] *= k
+		start.Offsets[i/2] *= k
+		if newCert, ok := withStart(cert, start); ok {
+			cert = newCert
+		}
+	}
+	return cert
+}
+
+//moves as much of the buffer as possible into the wall on the other side of it
+func minimizeBuffer(cert fullCert) fullCert {
+	for bufSize := 0; bufSize < len(cert.Start.Buffer); bufSize++ {
+		start := copyStart(cert.Start)
+		cut := len(start.Buffer) - bufSize
+		switch start.Dir {
+		case L:
+			start.Words[start.pos()+1] = append(append(word{}, start.Buffer[bufSize:]...), start.Words[start.pos()+1]...)
+			start.Buffer = append(word{}, start.Buffer[:bufSize]...)
+		case R:
+			start.Words[start.pos()-1] = append(append(word{}, start.Words[start.pos()-1]...), start.Buffer[:cut]...)
+			start.Buffer = append(word{}, start.Buffer[cut:]...)
+		}
+		if newCert, ok := withStart(cert, start); ok {
+			return newCert
+		}
+	}
+	return cert
+}
+
+//moves copies of the repeaters out of the walls next to them into the offsets
+func minimizeWalls(cert fullCert) fullCert {
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < len(cert.Start.Words); i += 2 {
+			start := copyStart(cert.Start)
+			repeater := start.Words[i]
+			left, right := start.Words[i-1], start.Words[i+1]
+			switch {
+			//the buffer separates the repeater at the head from the wall behind the head
+			case hasSuffix(left, repeater) && !(i == start.pos() && start.Dir == R):
+				start.Words[i-1] = append(word{}, left[:len(left)-len(repeater)]...)
+			case hasPrefix(right, repeater) && !(i == start.pos() && start.Dir == L):
+				start.Words[i+1] = append(word{}, right[len(repeater):]...)
+			default:
+				continue
+			}
+			start.Offsets[i/2] += 1
+			if newCert, ok := withStart(cert, start); ok {
+				cert = newCert
+				changed = true
+			}
+		}
+	}
+	return cert
+}
+
+//C(n) with offsets o_i is C'(n+1) with offsets o_i - m_i,
+//which the tm reaches one cycle earlier
+func minimizeSteps(cert fullCert) fullCert {
+	for {
+		start := copyStart(cert.Start)
+		for i := 1; i < len(start.Words); i += 2 {
+			start.Offsets[i/2] -= start.Multipliers[i/2]
+			if start.Offsets[i/2] < 0 {
+				return cert
+			}
+		}
+		start.Steps -= cycleStepsAt(start, cert.Rules, 0)
+		newCert := cert
+		newCert.Start = start
+		if start.Steps < 0 || !verifyBouncer(newCert) {
+			return cert
+		}
+		cert = newCert
+	}
+}
+
+//the number of steps the rules take to get from C(n) to C(n+1)
+func cycleStepsAt(start initialConditions, rules []transitionRule, n int) int {
+	steps := 0
+	pos := start.pos()
+	for i, rule := range rules {
+		if i%2 == 0 {
+			multiplier, offset := start.repetitions(pos)
+			steps += rule.Steps * (multiplier*n + offset)
+		} else {
+			steps += rule.Steps
+		}
+		switch rule.EndDir {
+		case L:
+			pos -= 1
+		case R:
+			pos += 1
+		}
+	}
+	return steps
+}
+
+//withStart derives the rules for a new description of the same bouncer
+func withStart(cert fullCert, start initialConditions) (fullCert, bool) {
+	tm := cert.Tm
+	if cert.Mirror {
+		tm = tm.mirror()
+	}
+	rules := findRules(tm, start, start, cycleStepsFor(cert.Start, cert.Rules, start))
+	if rules == nil {
+		return cert, false
+	}
+	newCert := fullCert{
+		Tm:     cert.Tm,
+		Mirror: cert.Mirror,
+		Start:  start,
+		Rules:  rules,
+	}
+	if !verifyBouncer(newCert) {
+		return cert, false
+	}
+	return newCert, true
+}
+
+//the step count of the cycle from start, which describes the same bouncer as old.
+//The tm takes as many steps from C(0) to C(1) as before, but every chain rule only counts
+//a single copy of its repeater and a copy of a shorter repeater takes its share of the steps.
+func cycleStepsFor(old initialConditions, rules []transitionRule, start initialConditions) int {
+	steps := cycleStepsAt(old, rules, 0)
+	pos := old.pos()
+	for i, rule := range rules {
+		if i%2 == 0 && pos%2 == 1 {
+			_, offset := start.repetitions(pos)
+			steps -= rule.Steps * len(start.Words[pos]) / len(old.Words[pos]) * (offset - 1)
+		}
+		switch rule.EndDir {
+		case L:
+			pos -= 1
+		case R:
+			pos += 1
+		}
+	}
+	return steps
+}
+
+//a copy with explicit multipliers and offsets that can be changed safely
+func copyStart(start initialConditions) initialConditions {
+	newStart := start
+	newStart.Words = make([]word, len(start.Words))
+	copy(newStart.Words, start.Words)
+	newStart.Buffer = append(word{}, start.Buffer...)
+	newStart.Pos = start.pos()
+	newStart.Multipliers = make([]int, len(start.Words)/2)
+	newStart.Offsets = make([]int, len(start.Words)/2)
+	for i := 1; i < len(start.Words); i += 2 {
+		newStart.Multipliers[i/2], newStart.Offsets[i/2] = start.repetitions(i)
+	}
+	return newStart
+}
+
+//leaves out multipliers and offsets that have their default values
+func normalizeRepetitions(start initialConditions) initialConditions {
+	start = copyStart(start)
+	allOnes, allZeros := true, true
+	for i := range start.Multipliers {
+		allOnes = allOnes && start.Multipliers[i] == 1
+		allZeros = allZeros && start.Offsets[i] == 0
+	}
+	if allOnes {
+		start.Multipliers = nil
+	}
+	if allZeros {
+		start.Offsets = nil
+	}
+	return start
+}
+
+func primitiveRoot(w word) word {
+	for period := 1; period < len(w); period++ {
+		if len(w)%period == 0 && reflect.DeepEqual(w[:period].repeat(len(w)/period), w) {
+			return w[:period]
+		}
+	}
+	return w
+}
+
+func hasPrefix(w word, prefix word) bool {
+	return len(prefix) > 0 && len(w) >= len(prefix) && reflect.DeepEqual(w[:len(prefix)], prefix)
+}
+
+func hasSuffix(w word, suffix word) bool {
+	return len(suffix) > 0 && len(w) >= len(suffix) && reflect.DeepEqual(w[len(w)-len(suffix):], suffix)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+)
+
+//how long normalize simulates the tm to find the order of the states and symbols
+const normalizeSteps = 10000
+
+//tmMapping turns a tm into an isomorphic one: state i becomes States[i], symbol j becomes Symbols[j]
+//and L and R are swapped if Mirror is set
+type tmMapping struct {
+	States  []tmState
+	Symbols []baseSymbol
+	Mirror  bool
+}
+
+//normalize relabels the states in the order the tm reaches them from A0 and the symbols in the order
+//it first writes them, like in Tree Normal Form. With mirror the tm is also mirrored if its first move
+//goes to the left. States and symbols that don't show up within normalizeSteps keep their order behind the others.
+func (tm turingMachine) normalize(mirror bool) (turingMachine, tmMapping) {
+	m := tmMapping{
+		States:  make([]tmState, tm.numStates),
+		Symbols: make([]baseSymbol, tm.numSymbols),
+	}
+	for i := range m.States {
+		m.States[i] = -1
+	}
+	for i := range m.Symbols {
+		m.Symbols[i] = -1
+	}
+	nextState, nextSymbol := tmState(1), baseSymbol(1)
+	m.States[A], m.Symbols[0] = A, 0
+	if tr, ok := tm.transitions[headConfig{A, 0}]; ok && mirror && tr.direction == L {
+		m.Mirror = true
+	}
+
+	tape := map[int]baseSymbol{}
+	pos := 0
+	headCon := headConfig{}
+	for steps := 0; steps < normalizeSteps && (int(nextState) < tm.numStates || int(nextSymbol) < tm.numSymbols); steps++ {
+		tr, ok := tm.transitions[headCon]
+		if !ok {
+			break
+		}
+		if m.Symbols[tr.symbol] < 0 {
+			m.Symbols[tr.symbol] = nextSymbol
+			nextSymbol++
+		}
+		if m.States[tr.state] < 0 {
+			m.States[tr.state] = nextState
+			nextState++
+		}
+		tape[pos] = tr.symbol
+		switch tr.direction {
+		case L:
+			pos--
+		case R:
+			pos++
+		}
+		headCon = headConfig{tr.state, tape[pos]}
+	}
+	for i := range m.States {
+		if m.States[i] < 0 {
+			m.States[i] = nextState
+			nextState++
+		}
+	}
+	for i := range m.Symbols {
+		if m.Symbols[i] < 0 {
+			m.Symbols[i] = nextSymbol
+			nextSymbol++
+		}
+	}
+	return tm.transform(m), m
+}
+
+//valid checks that m relabels the states and symbols of tm one to one
+func (m tmMapping) valid(tm turingMachine) bool {
+	if len(m.States) != tm.numStates || len(m.Symbols) != tm.numSymbols {
+		return false
+	}
+	seenStates := map[tmState]bool{}
+	for _, state := range m.States {
+		if state < 0 || int(state) >= tm.numStates || seenStates[state] {
+			return false
+		}
+		seenStates[state] = true
+	}
+	seenSymbols := map[baseSymbol]bool{}
+	for _, sy := range m.Symbols {
+		if sy < 0 || int(sy) >= tm.numSymbols || seenSymbols[sy] {
+			return false
+		}
+		seenSymbols[sy] = true
+	}
+	return true
+}
+
+func (m tmMapping) inverse() tmMapping {
+	inverse := tmMapping{
+		States:  make([]tmState, len(m.States)),
+		Symbols: make([]baseSymbol, len(m.Symbols)),
+		Mirror:  m.Mirror,
+	}
+	for i, state := range m.States {
+		inverse.States[state] = tmState(i)
+	}
+	for i, sy := range m.Symbols {
+		inverse.Symbols[sy] = baseSymbol(i)
+	}
+	return inverse
+}
+
+//then is the mapping that applies m first and next afterwards, without m there is nothing to combine
+func (m *tmMapping) then(next tmMapping) *tmMapping {
+	if m == nil {
+		return nil
+	}
+	combined := tmMapping{
+		States:  make([]tmState, len(m.States)),
+		Symbols: make([]baseSymbol, len(m.Symbols)),
+		Mirror:  m.Mirror != next.Mirror,
+	}
+	for i, state := range m.States {
+		combined.States[i] = next.States[state]
+	}
+	for i, sy := range m.Symbols {
+		combined.Symbols[i] = next.Symbols[sy]
+	}
+	return &combined
+}
+
+func (tm turingMachine) transform(m tmMapping) turingMachine {
+	newTm := turingMachine{
+		numStates:   tm.numStates,
+		numSymbols:  tm.numSymbols,
+		transitions: map[headConfig]transition{},
+	}
+	for hc, tr := range tm.transitions {
+		if m.Mirror {
+			tr.direction = !tr.direction
+		}
+		newTm.transitions[headConfig{m.States[hc.state], m.Symbols[hc.symbol]}] = transition{m.Symbols[tr.symbol], tr.direction, m.States[tr.state]}
+	}
+	return newTm
+}
+
+func (m tmMapping) word(w word) word {
+	newWord := make(word, len(w))
+	for i, sy := range w {
+		newWord[i] = m.Symbols[sy]
+	}
+	return newWord
+}
+
+//the mirrored proof is also a proof for the mirrored tm, so only the states and symbols need to be relabelled after mirroring
+func (m tmMapping) start(start initialConditions) initialConditions {
+	if m.Mirror {
+		start = start.mirror()
+	}
+	newStart := start
+	newStart.Words = make([]word, len(start.Words))
+	for i, w := range start.Words {
+		newStart.Words[i] = m.word(w)
+	}
+	newStart.State = m.States[start.State]
+	newStart.Buffer = m.word(start.Buffer)
+	return newStart
+}
+
+func (m tmMapping) rules(rules []transitionRule) []transitionRule {
+	newRules := make([]transitionRule, len(rules))
+	for i, rule := range rules {
+		if m.Mirror {
+			rule = rule.mirror()
+		}
+		rule.StartWord = m.word(rule.StartWord)
+		rule.StartState = m.States[rule.StartState]
+		rule.StartBuffer = m.word(rule.StartBuffer)
+		rule.EndWord = m.word(rule.EndWord)
+		rule.EndState = m.States[rule.EndState]
+		rule.EndBuffer = m.word(rule.EndBuffer)
+		rule.Stub = m.word(rule.Stub)
+		newRules[i] = rule
+	}
+	return newRules
+}
+
+//transform turns the certificate into one for the tm transformed by m.
+//A recorded mapping still leads from the original tm to the new one.
+func (cert fullCert) transform(m tmMapping) fullCert {
+	newCert := fullCert{
+		Tm:      cert.Tm.transform(m),
+		Mirror:  cert.Mirror,
+		Start:   m.start(cert.Start),
+		Rules:   m.rules(cert.Rules),
+		Mapping: cert.Mapping.then(m),
+	}
+	for _, variant := range cert.Variants {
+		newCert.Variants = append(newCert.Variants, fullVariant{m.start(variant.Start), m.rules(variant.Rules)})
+	}
+	return newCert
+}
+
+func (cert shortCert) transform(m tmMapping) shortCert {
+	newCert := shortCert{
+		Tm:         cert.Tm.transform(m),
+		Mirror:     cert.Mirror,
+		Start:      m.start(cert.Start),
+		CycleSteps: cert.CycleSteps,
+		Mapping:    cert.Mapping.then(m),
+	}
+	for _, variant := range cert.Variants {
+		newCert.Variants = append(newCert.Variants, shortVariant{m.start(variant.Start), variant.CycleSteps})
+	}
+	return newCert
+}
+
+//original transports a certificate for a normalized tm back to the tm it was normalized from
+func (cert fullCert) original() (fullCert, error) {
+	if cert.Mapping == nil {
+		return cert, nil
+	}
+	if err := cert.checkLabels(); err != nil {
+		return fullCert{}, err
+	}
+	original := cert.transform(cert.Mapping.inverse())
+	original.Mapping = nil
+	return original, nil
+}
+
+//checkLabels makes sure that every state and symbol of the certificate is one of its tm,
+//a mapping only relabels those, so it has to be called before transform for unverified certificates
+func (cert fullCert) checkLabels() error {
+	starts := []initialConditions{cert.Start}
+	rules := append([]transitionRule{}, cert.Rules...)
+	for _, variant := range cert.Variants {
+		starts = append(starts, variant.Start)
+		rules = append(rules, variant.Rules...)
+	}
+	words := []word{}
+	states := []tmState{}
+	for _, start := range starts {
+		words = append(append(words, start.Words...), start.Buffer)
+		states = append(states, start.State)
+	}
+	for _, rule := range rules {
+		words = append(words, rule.StartWord, rule.StartBuffer, rule.EndWord, rule.EndBuffer, rule.Stub)
+		states = append(states, rule.StartState, rule.EndState)
+	}
+	for _, w := range words {
+		for _, sy := range w {
+			if sy < 0 || int(sy) >= cert.Tm.numSymbols {
+				return fmt.Errorf("symbol %d is not one of the %d symbols of the tm", sy, cert.Tm.numSymbols)
+			}
+		}
+	}
+	for _, state := range states {
+		if state < 0 || int(state) >= cert.Tm.numStates {
+			return fmt.Errorf("state %v is not one of the %d states of the tm", state, cert.Tm.numStates)
+		}
+	}
+	return nil
+}
+
+//originalTm is the tm the certificate was found for
+func (cert fullCert) originalTm() turingMachine {
+	return cert.Mapping.originalTm(cert.Tm)
+}
+
+//originalTm is the tm that m normalized to tm, without a mapping it is tm itself
+func (m *tmMapping) originalTm(tm turingMachine) turingMachine {
+	if m == nil {
+		return tm
+	}
+	return tm.transform(m.inverse())
+}
+
+//the claims of a cycler don't depend on the names of the states and symbols
+func (cert cyclerCert) original() cyclerCert {
+	cert.Tm = cert.Mapping.originalTm(cert.Tm)
+	cert.Mapping = nil
+	return cert
+}
+
+//the records of a translated cycler are at the other end of the original tm if it was mirrored
+func (cert translatedCyclerCert) original() translatedCyclerCert {
+	if cert.Mapping != nil && cert.Mapping.Mirror {
+		cert.Mirror = !cert.Mirror
+	}
+	cert.Tm = cert.Mapping.originalTm(cert.Tm)
+	cert.Mapping = nil
+	return cert
+}
+
+//findMapping looks for a relabelling of the states and symbols of from, possibly mirrored, that gives to.
+//A and the blank symbol stay the same, the tm has to start in the same configuration.
+func findMapping(from turingMachine, to turingMachine) (tmMapping, bool) {
+	if from.numStates != to.numStates || from.numSymbols != to.numSymbols {
+		return tmMapping{}, false
+	}
+	target := to.String()
+	for _, states := range permutations(from.numStates) {
+		for _, symbols := range permutations(from.numSymbols) {
+			for _, mirror := range []bool{false, true} {
+				m := tmMapping{States: make([]tmState, len(states)), Symbols: make([]baseSymbol, len(symbols)), Mirror: mirror}
+				for i, state := range states {
+					m.States[i] = tmState(state)
+				}
+				for i, sy := range symbols {
+					m.Symbols[i] = baseSymbol(sy)
+				}
+				if from.transform(m).String() == target {
+					return m, true
+				}
+			}
+		}
+	}
+	return tmMapping{}, false
+}
+
+//all permutations of 0..n-1 that keep 0 in place
+func permutations(n int) [][]int {
+	if n <= 1 {
+		return [][]int{make([]int, n)}
+	}
+	result := [][]int{}
+	for _, shorter := range permutations(n - 1) {
+		//insert n-1 at every position except 0
+		for i := 1; i <= len(shorter); i++ {
+			perm := append(append(append([]int{}, shorter[:i]...), n-1), shorter[i:]...)
+			result = append(result, perm)
+		}
+	}
+	return result
+}
+
+//transportCert verifies a certificate and turns it into one for an isomorphic tm, which is verified again
+func transportCert(cert fullCert, to turingMachine) (fullCert, error) {
+	if err := cert.checkLabels(); err != nil {
+		return fullCert{}, fmt.Errorf("The certificate for %v is invalid: %w", cert.Tm, err)
+	}
+	if err := explainBouncer(cert); err != nil {
+		return fullCert{}, fmt.Errorf("The certificate for %v doesn't verify: %w", cert.Tm, err)
+	}
+	m, ok := findMapping(cert.Tm, to)
+	if !ok {
+		return fullCert{}, fmt.Errorf("%v is not isomorphic to %v", to, cert.Tm)
+	}
+	newCert := cert.transform(m)
+	if !verifyBouncer(newCert) {
+		return fullCert{}, fmt.Errorf("The transformed certificate for %v doesn't verify", to)
+	}
+	return newCert, nil
+}
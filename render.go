@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	imagecolor "image/color"
+	"image/png"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+//diagram is the space-time diagram of a tm: one row per step, with the overlays that are drawn on top of it
+type diagram struct {
+	numStates int
+	minPos    int
+	maxPos    int
+	rows      []diagramRow
+	records   []diagramRecord
+	segments  []diagramSegment
+}
+
+//the configuration after a step, origin is the absolute position of tape[0]
+type diagramRow struct {
+	origin int
+	tape   []baseSymbol
+	pos    int
+	state  tmState
+}
+
+//a record from findRecords, the head is on the new cell
+type diagramRecord struct {
+	step int
+	pos  int
+	dir  direction
+}
+
+//a word or the buffer of C(n) in the row where the tm reaches C(n), from and to are absolute positions with to excluded
+type diagramSegment struct {
+	step  int
+	from  int
+	to    int
+	kind  string //wall, repeater or buffer
+	index int    //the index of the word
+}
+
+//buildDiagram runs the tm from the blank tape for at most steps steps with runTm
+func buildDiagram(tm turingMachine, steps int) diagram {
+	growth := map[direction]bool{L: true, R: true}
+	d := diagram{numStates: tm.numStates}
+	row := diagramRow{tape: []baseSymbol{0}}
+	d.rows = append(d.rows, row)
+	for step := 1; step <= steps; step++ {
+		if _, ok := tm.transitions[headConfig{row.state, row.tape[row.pos]}]; !ok {
+			break
+		}
+		state, pos, tape, _ := runTm(tm, row.state, row.pos, row.tape, 1, growth)
+		origin := row.origin
+		if len(tape) > len(row.tape) && pos == 0 {
+			//runTm put a new cell in front of the tape
+			origin--
+		}
+		row = diagramRow{origin, tape, pos, state}
+		d.rows = append(d.rows, row)
+		if origin < d.minPos {
+			d.minPos = origin
+		}
+		if origin+len(tape)-1 > d.maxPos {
+			d.maxPos = origin + len(tape) - 1
+		}
+	}
+	return d
+}
+
+func (d *diagram) addRecords(tm turingMachine) {
+	records := findRecords(tm, len(d.rows)-1)
+	for _, dir := range []direction{L, R} {
+		for _, rec := range records[dir] {
+			row := d.rows[rec.steps]
+			d.records = append(d.records, diagramRecord{rec.steps, row.origin + row.pos, dir})
+		}
+	}
+}
+
+//addSegments looks for C(0), C(1), ... of the certificate in the rows and marks its words and buffer.
+//C(n) belongs to the variant n modulo the number of variants, Start counting as the first one.
+func (d *diagram) addSegments(cert fullCert) {
+	starts := []initialConditions{cert.Start}
+	for _, variant := range cert.Variants {
+		starts = append(starts, variant.Start)
+	}
+	step := cert.Start.Steps
+	for n := 0; step < len(d.rows); n++ {
+		start := starts[n%len(starts)]
+		if !checkShape(start) {
+			return
+		}
+		tape, pos, segments := layoutConfig(start, n)
+		if cert.Mirror {
+			tape = word(tape).reverse()
+			pos = len(tape) - 1 - pos
+			for i := range segments {
+				segments[i].from, segments[i].to = len(tape)-segments[i].to, len(tape)-segments[i].from
+			}
+		}
+		for ; step < len(d.rows); step++ {
+			row := d.rows[step]
+			if row.state == start.State && row.pos == pos && reflect.DeepEqual(row.tape, tape) {
+				break
+			}
+		}
+		if step == len(d.rows) {
+			return
+		}
+		for _, segment := range segments {
+			segment.step = step
+			segment.from += d.rows[step].origin
+			segment.to += d.rows[step].origin
+			d.segments = append(d.segments, segment)
+		}
+		step++
+	}
+}
+
+//layoutConfig builds the tape of C(n) like checkInitialConditions and returns where each word and the buffer are on it
+func layoutConfig(start initialConditions, n int) ([]baseSymbol, int, []diagramSegment) {
+	tape := []baseSymbol{}
+	segments := []diagramSegment{}
+	addWord := func(w word, kind string, index int) {
+		if len(w) > 0 {
+			segments = append(segments, diagramSegment{from: len(tape), to: len(tape) + len(w), kind: kind, index: index})
+		}
+		tape = append(tape, w...)
+	}
+	addWords := func(from int, to int) {
+		for i := from; i < to; i++ {
+			kind := "wall"
+			if i%2 == 1 {
+				kind = "repeater"
+			}
+			addWord(start.power(i, n), kind, i)
+		}
+	}
+	split := start.pos()
+	if start.Dir == L {
+		split += 1
+	}
+	addWords(0, split)
+	pos := len(tape) - 1
+	if start.Dir == R {
+		pos = len(tape) + len(start.Buffer)
+	}
+	addWord(start.Buffer, "buffer", -1)
+	addWords(split, len(start.Words))
+	return tape, pos, segments
+}
+
+var symbolColors = []imagecolor.RGBA{
+	{255, 255, 255, 255},
+	{50, 50, 50, 255},
+	{80, 130, 210, 255},
+	{210, 150, 50, 255},
+	{110, 180, 80, 255},
+	{170, 80, 170, 255},
+	{70, 180, 180, 255},
+	{190, 70, 70, 255},
+	{140, 140, 50, 255},
+	{110, 110, 170, 255},
+}
+
+var segmentColors = map[string]imagecolor.RGBA{
+	"wall":     {60, 100, 255, 255},
+	"repeater": {30, 200, 70, 255},
+	"buffer":   {255, 140, 0, 255},
+}
+
+var recordColors = map[direction]imagecolor.RGBA{
+	L: {220, 30, 30, 255},
+	R: {30, 30, 220, 255},
+}
+
+//the head is drawn in a shade of red that gets lighter with the state
+func stateColor(state tmState, numStates int) imagecolor.RGBA {
+	shade := uint8(180 * int(state) / numStates)
+	return imagecolor.RGBA{255, shade, shade / 2, 255}
+}
+
+func blend(a imagecolor.RGBA, b imagecolor.RGBA) imagecolor.RGBA {
+	mix := func(x uint8, y uint8) uint8 {
+		return uint8((int(x) + int(y)) / 2)
+	}
+	return imagecolor.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
+
+//cellColors returns the color of every cell of every row, the segments are blended into the cells they cover
+func (d diagram) cellColors() [][]imagecolor.RGBA {
+	width := d.maxPos - d.minPos + 1
+	cells := make([][]imagecolor.RGBA, len(d.rows))
+	for step, row := range d.rows {
+		cells[step] = make([]imagecolor.RGBA, width)
+		for x := range cells[step] {
+			cells[step][x] = symbolColors[0]
+		}
+		for i, sy := range row.tape {
+			cells[step][row.origin+i-d.minPos] = symbolColors[int(sy)%len(symbolColors)]
+		}
+		cells[step][row.origin+row.pos-d.minPos] = stateColor(row.state, d.numStates)
+	}
+	for _, segment := range d.segments {
+		for pos := segment.from; pos < segment.to; pos++ {
+			cells[segment.step][pos-d.minPos] = blend(cells[segment.step][pos-d.minPos], segmentColors[segment.kind])
+		}
+	}
+	return cells
+}
+
+//writePNG draws every cell as a square of scale pixels, the records are marked in a margin on their side
+func (d diagram) writePNG(output io.Writer, scale int) error {
+	cells := d.cellColors()
+	margin := 2 * scale
+	width := len(cells[0])*scale + 2*margin
+	img := image.NewRGBA(image.Rect(0, 0, width, len(cells)*scale))
+	fill := func(x0 int, y0 int, w int, c imagecolor.RGBA) {
+		for y := y0; y < y0+scale; y++ {
+			for x := x0; x < x0+w; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	for step, row := range cells {
+		fill(0, step*scale, width, imagecolor.RGBA{255, 255, 255, 255})
+		for x, c := range row {
+			fill(margin+x*scale, step*scale, scale, c)
+		}
+	}
+	for _, rec := range d.records {
+		x := 0
+		if rec.dir == R {
+			x = width - margin
+		}
+		fill(x, rec.step*scale, margin, recordColors[rec.dir])
+	}
+	return png.Encode(output, img)
+}
+
+//writeSVG draws runs of cells with the same color as one rectangle, the segments get a title with their word
+func (d diagram) writeSVG(output io.Writer, scale int) error {
+	cells := d.cellColors()
+	margin := 2 * scale
+	width := len(cells[0])*scale + 2*margin
+	w := bufio.NewWriter(output)
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" shape-rendering=\"crispEdges\">\n", width, len(cells)*scale)
+	rect := func(x int, y int, rectWidth int, c imagecolor.RGBA, title string) {
+		fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\"", x, y, rectWidth, scale, c.R, c.G, c.B)
+		if title == "" {
+			fmt.Fprintln(w, "/>")
+			return
+		}
+		fmt.Fprintf(w, "><title>%s</title></rect>\n", title)
+	}
+	for step, row := range cells {
+		for x := 0; x < len(row); {
+			end := x + 1
+			for end < len(row) && row[end] == row[x] {
+				end++
+			}
+			if row[x] != symbolColors[0] {
+				rect(margin+x*scale, step*scale, (end-x)*scale, row[x], "")
+			}
+			x = end
+		}
+	}
+	for _, rec := range d.records {
+		x := 0
+		if rec.dir == R {
+			x = width - margin
+		}
+		rect(x, rec.step*scale, margin, recordColors[rec.dir], fmt.Sprintf("record at step %d", rec.step))
+	}
+	for _, segment := range d.segments {
+		title := segment.kind
+		if segment.index >= 0 {
+			title = fmt.Sprintf("%s w_%d", segment.kind, segment.index)
+		}
+		fmt.Fprintf(w, "<rect class=\"%s\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"black\" stroke-width=\"0.5\"><title>%s at step %d</title></rect>\n",
+			segment.kind, margin+(segment.from-d.minPos)*scale, segment.step*scale, (segment.to-segment.from)*scale, scale, title, segment.step)
+	}
+	fmt.Fprintln(w, "</svg>")
+	return w.Flush()
+}
+
+//renderInput draws the first tm or certificate of the input into path, as SVG if the name ends with .svg and as PNG otherwise.
+//Certificates of bouncers get the segmentation of their configurations C(n) drawn over the diagram.
+func renderInput(input *bufio.Reader, path string, steps int, scale int) error {
+	text := ""
+	var readerErr error
+	for text == "" && readerErr == nil {
+		text, readerErr = input.ReadString('\n')
+		text = strings.TrimSpace(text)
+	}
+	if text == "" {
+		return errors.New("Unable to render, the input is empty")
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	var tm turingMachine
+	var cert *fullCert
+	if strings.HasPrefix(text, "{") {
+		v, err := findVerifier([]byte(text))
+		if err != nil {
+			return err
+		}
+		parsed, ok, err := v.verify([]byte(text))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "The certificate doesn't verify, its segmentation is only drawn where it matches")
+		}
+		switch parsed := parsed.(type) {
+		case fullCert:
+			cert = &parsed
+			tm = parsed.Tm
+		case cyclerCert:
+			tm = parsed.Tm
+		case translatedCyclerCert:
+			tm = parsed.Tm
+		default:
+			return errors.New("Unable to render a certificate that can't be expanded")
+		}
+	} else {
+		tm = parseTM(text)
+		if tm.numStates == 0 {
+			return fmt.Errorf("Unable to parse %s", text)
+		}
+	}
+
+	d := buildDiagram(tm, steps)
+	d.addRecords(tm)
+	if cert != nil {
+		d.addSegments(*cert)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(strings.ToLower(path), ".svg") {
+		err = d.writeSVG(file, scale)
+	} else {
+		err = d.writePNG(file, scale)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+//certSchema describes all certificate formats as a JSON Schema, derived from the Go types
+//so it can't get out of sync with them. Fields tagged schema:"optional" are missing in older
+//certificates, fields with omitempty are missing if they have their default value.
+func certSchema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	formats := []struct {
+		cert   interface{}
+		format string
+	}{
+		{fullCert{}, fullFormat},
+		{shortCert{}, shortFormat},
+		{cyclerCert{}, cyclerFormat},
+		{translatedCyclerCert{}, translatedCyclerFormat},
+	}
+	oneOf := []interface{}{}
+	for _, f := range formats {
+		ref := schemaOf(reflect.TypeOf(f.cert), defs)
+		name := reflect.TypeOf(f.cert).Name()
+		properties := defs[name].(map[string]interface{})["properties"].(map[string]interface{})
+		properties["Format"] = map[string]interface{}{"const": f.format}
+		properties["Version"] = map[string]interface{}{"type": "integer", "minimum": 0, "maximum": certVersion}
+		oneOf = append(oneOf, ref)
+	}
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Certificate",
+		"oneOf":   oneOf,
+		"$defs":   defs,
+	}
+}
+
+//the types that are written as strings by their MarshalText
+var textSchemas = map[reflect.Type]map[string]interface{}{
+	reflect.TypeOf(turingMachine{}): {"type": "string", "pattern": "^([0-9][LR][A-Z]|---)+(_([0-9][LR][A-Z]|---)+)*$"},
+	reflect.TypeOf(tmState(0)):      {"type": "string", "pattern": "^[A-Z]$"},
+	reflect.TypeOf(direction(L)):    {"type": "string", "enum": []string{"L", "R"}},
+	reflect.TypeOf(word{}):          {"type": "string", "pattern": "^[0-9]*$"},
+}
+
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if schema, ok := textSchemas[t]; ok {
+		return schema
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), defs)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			properties := map[string]interface{}{}
+			required := []string{}
+			defs[t.Name()] = map[string]interface{}{
+				"type":                 "object",
+				"properties":           properties,
+				"additionalProperties": false,
+			}
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				properties[field.Name] = schemaOf(field.Type, defs)
+				if field.Tag.Get("schema") != "optional" && !strings.Contains(field.Tag.Get("json"), "omitempty") {
+					required = append(required, field.Name)
+				}
+			}
+			defs[t.Name()].(map[string]interface{})["required"] = required
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	panic("no schema for " + t.String())
+}
+
+func printSchema(output io.Writer) {
+	b, err := json.MarshalIndent(certSchema(), "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(output, string(b))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//limits of the verification service for one request
+const (
+	maxRequestBytes = 64 << 20
+	maxRequestCerts = 10000
+)
+
+//verdict is the result for one certificate of a request, Index counts from 1
+type verdict struct {
+	Index  int
+	Tm     string `json:",omitempty"`
+	Format string `json:",omitempty"`
+	Valid  bool
+	Error  string `json:",omitempty"`
+}
+
+//splitCerts accepts a single certificate, a JSON array of certificates or one certificate per line
+func splitCerts(body []byte) [][]byte {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && json.Valid(body) {
+		if body[0] != '[' {
+			return [][]byte{body}
+		}
+		var list []json.RawMessage
+		if err := json.Unmarshal(body, &list); err == nil {
+			certs := make([][]byte, len(list))
+			for i, cert := range list {
+				certs[i] = cert
+			}
+			return certs
+		}
+	}
+	certs := [][]byte{}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			certs = append(certs, line)
+		}
+	}
+	return certs
+}
+
+//verifyText checks one certificate of any format and explains why it isn't valid if its verifier can
+func verifyText(index int, text []byte) verdict {
+	result := verdict{Index: index}
+	probe := struct{ Tm string }{}
+	if err := json.Unmarshal(text, &probe); err != nil {
+		result.Error = fmt.Sprintf("Unable to parse the certificate: %s", err)
+		return result
+	}
+	result.Tm = probe.Tm
+	v, err := findVerifier(text)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Format = v.format()
+	_, ok, err := v.verify(text)
+	if err != nil {
+		result.Error = fmt.Sprintf("Unable to parse the certificate: %s", err)
+		return result
+	}
+	result.Valid = ok
+	if !ok {
+		result.Error = "The certificate doesn't verify"
+		if e, isExplainer := v.(explainer); isExplainer {
+			if err := e.explain(text); err != nil {
+				result.Error = err.Error()
+			}
+		}
+	}
+	return result
+}
+
+//verifyHandler answers a POST with the certificates of any format in the body with a JSON array of verdicts in the same order.
+//At most cap(workTokens) certificates are verified at the same time over all requests.
+func verifyHandler(workTokens chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Certificates have to be sent with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		certs := splitCerts(body)
+		if len(certs) > maxRequestCerts {
+			http.Error(w, fmt.Sprintf("At most %d certificates per request", maxRequestCerts), http.StatusRequestEntityTooLarge)
+			return
+		}
+		verdicts := make([]verdict, len(certs))
+		var wg sync.WaitGroup
+		for i, text := range certs {
+			i, text := i, text
+			_ = <-workTokens
+			wg.Add(1)
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						verdicts[i] = verdict{Index: i + 1, Error: fmt.Sprintf("Panic: %v", err)}
+					}
+					workTokens <- struct{}{}
+					wg.Done()
+				}()
+				verdicts[i] = verifyText(i+1, text)
+			}()
+		}
+		wg.Wait()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(verdicts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	})
+}
+
+//serve runs the verification service on addr with the endpoint /verify.
+//Slow clients are cut off, the write timeout also bounds the time to verify a request.
+func serve(addr string, workTokens chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/verify", verifyHandler(workTokens))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      10 * time.Minute,
+		IdleTimeout:       time.Minute,
+	}
+	fmt.Fprintf(os.Stderr, "Verifying certificates at http://%s/verify\n", addr)
+	return server.ListenAndServe()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//inputSlice selects the items of the input by their index from 0: lines of text, bbchallenge records or binary certificates.
+//Item k is selected if start <= k < end and k mod shards is shard.
+type inputSlice struct {
+	shard  int
+	shards int
+	start  int
+	end    int //-1 for the end of the input
+}
+
+var wholeInput = inputSlice{0, 1, 0, -1}
+
+func (s inputSlice) contains(index int) bool {
+	return index >= s.start && (s.end < 0 || index < s.end) && index%s.shards == s.shard
+}
+
+//after reports whether no item from index on is selected
+func (s inputSlice) after(index int) bool {
+	return s.end >= 0 && index >= s.end
+}
+
+//parseSlice reads -shard=i/N and -range=start:end, either bound of the range can be left out
+func parseSlice(shard string, inputRange string) (inputSlice, error) {
+	s := wholeInput
+	if shard != "" {
+		parts := strings.Split(shard, "/")
+		ok := len(parts) == 2
+		if ok {
+			i, iErr := strconv.Atoi(parts[0])
+			n, nErr := strconv.Atoi(parts[1])
+			ok = iErr == nil && nErr == nil && i >= 0 && i < n
+			s.shard, s.shards = i, n
+		}
+		if !ok {
+			return wholeInput, fmt.Errorf("Unable to parse the shard %s, expected i/N with 0 <= i < N", shard)
+		}
+	}
+	if inputRange != "" {
+		parts := strings.Split(inputRange, ":")
+		ok := len(parts) == 2
+		if ok && parts[0] != "" {
+			start, err := strconv.Atoi(parts[0])
+			ok = err == nil && start >= 0
+			s.start = start
+		}
+		if ok && parts[1] != "" {
+			end, err := strconv.Atoi(parts[1])
+			ok = err == nil && end >= s.start
+			s.end = end
+		}
+		if !ok {
+			return wholeInput, fmt.Errorf("Unable to parse the range %s, expected start:end with 0 <= start <= end", inputRange)
+		}
+	}
+	return s, nil
+}
+
+//sliceInput passes on the selected items of the input in the same format. The format is recognized once the
+//input is read, so modes that don't read it aren't blocked. bbcSize is the size of the machines in bbchallenge records,
+//the header of the bbchallenge database doesn't count as an item.
+func sliceInput(input *bufio.Reader, s inputSlice, bbcSize string) *bufio.Reader {
+	if s == wholeInput {
+		return input
+	}
+	r, w := io.Pipe()
+	go func() {
+		output := bufio.NewWriter(w)
+		var err error
+		switch {
+		case isBinary(input):
+			err = sliceBinaryCerts(input, output, s)
+		case isBbchallenge(input):
+			err = sliceBbchallenge(input, output, s, bbcSize)
+		default:
+			err = sliceLines(input, output, s)
+		}
+		if flushErr := output.Flush(); err == nil {
+			err = flushErr
+		}
+		w.CloseWithError(err)
+	}()
+	return bufio.NewReader(r)
+}
+
+func sliceLines(input *bufio.Reader, output *bufio.Writer, s inputSlice) error {
+	for index := 0; !s.after(index); index++ {
+		line, err := input.ReadString('\n')
+		if line != "" && s.contains(index) {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if _, err := output.WriteString(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sliceBbchallenge(input *bufio.Reader, output *bufio.Writer, s inputSlice, bbcSize string) error {
+	numStates, numSymbols, err := parseClass(bbcSize)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, 3*numStates*numSymbols)
+	for index, first := 0, true; !s.after(index); first = false {
+		if _, err := io.ReadFull(input, record); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, ok := parseBbchallengeRecord(record, numStates, numSymbols); first && !ok {
+			//the header of the database
+			continue
+		}
+		if s.contains(index) {
+			if _, err := output.Write(record); err != nil {
+				return err
+			}
+		}
+		index++
+	}
+	return nil
+}
+
+func sliceBinaryCerts(input *bufio.Reader, output *bufio.Writer, s inputSlice) error {
+	head := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(input, head); err != nil {
+		return err
+	}
+	if _, err := output.Write(head); err != nil {
+		return err
+	}
+	for index := 0; !s.after(index); index++ {
+		length, err := binary.ReadUvarint(input)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Certificate %d is cut off", index)
+		}
+		if length > maxRecordLength {
+			return fmt.Errorf("Certificate %d is too long with %d bytes", index, length)
+		}
+		record, err := readRecord(input, length)
+		if err != nil {
+			return fmt.Errorf("Certificate %d is cut off", index)
+		}
+		if !s.contains(index) {
+			continue
+		}
+		prefix := make([]byte, binary.MaxVarintLen64)
+		if _, err := output.Write(prefix[:binary.PutUvarint(prefix, length)]); err != nil {
+			return err
+		}
+		if _, err := output.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//how many cells the stepper shows on each side of the head
+const stepperWindow = 30
+
+//how far the stepper runs the tm to find the next record or C(n)
+const stepperSearch = 1000000
+
+//ruleSpan is the application of one rule of a certificate during the cycle from C(n), from step start up to end
+type ruleSpan struct {
+	n       int
+	variant int //-1 for Start
+	rule    int
+	start   int
+	end     int
+}
+
+//certSchedule walks over the rules like checkApplication and returns the step of every C(n) and when each rule is applied.
+//A chain rule takes its steps once for every copy of the repeater it is applied to.
+func certSchedule(cert fullCert, stepLimit int) ([]int, []ruleSpan) {
+	starts := []initialConditions{cert.Start}
+	rules := [][]transitionRule{cert.Rules}
+	for _, variant := range cert.Variants {
+		starts = append(starts, variant.Start)
+		rules = append(rules, variant.Rules)
+	}
+	configs := []int{}
+	spans := []ruleSpan{}
+	step := cert.Start.Steps
+	for n := 0; step <= stepLimit; n++ {
+		v := n % len(starts)
+		start := starts[v]
+		configs = append(configs, step)
+		cycleStart := step
+		pos := start.pos()
+		for i, rule := range rules[v] {
+			steps := rule.Steps
+			if pos%2 == 1 {
+				multiplier, offset := start.repetitions(pos)
+				steps *= multiplier*n + offset
+			}
+			spans = append(spans, ruleSpan{n, v - 1, i, step, step + steps})
+			step += steps
+			if rule.EndDir == L {
+				pos--
+			} else {
+				pos++
+			}
+		}
+		if step == cycleStart {
+			break
+		}
+	}
+	return configs, spans
+}
+
+type stepper struct {
+	tm      turingMachine
+	cert    *fullCert
+	run     *machineRun
+	configs []int
+	spans   []ruleSpan
+}
+
+//newStepper steps the mirrored tm if the certificate is mirrored, so the tape looks like C(n) and the rules
+func newStepper(tm turingMachine, cert *fullCert) *stepper {
+	if cert != nil && cert.Mirror {
+		tm = tm.mirror()
+	}
+	s := &stepper{tm: tm, cert: cert, run: newMachineRun(tm)}
+	if cert != nil {
+		s.configs, s.spans = certSchedule(*cert, stepperSearch)
+	}
+	return s
+}
+
+//goTo runs the tm to the given step, from the start again if it is behind it
+func (s *stepper) goTo(step int) bool {
+	if step < s.run.steps {
+		s.run = newMachineRun(s.tm)
+	}
+	return s.run.run(step)
+}
+
+//nextRecord runs the tm until it visits a new cell at either end of the tape
+func (s *stepper) nextRecord() bool {
+	for s.run.steps < stepperSearch {
+		minPos, maxPos := s.run.minPos, s.run.maxPos
+		if !s.run.step() {
+			return false
+		}
+		if s.run.pos < minPos || s.run.pos > maxPos {
+			return true
+		}
+	}
+	return false
+}
+
+//config returns the step of C(n), or of the next C(n) after the current step if n is negative
+func (s *stepper) config(n int) (int, int, error) {
+	if s.cert == nil {
+		return 0, 0, errors.New("C(n) needs a certificate")
+	}
+	if n < 0 {
+		for i, step := range s.configs {
+			if step > s.run.steps {
+				return i, step, nil
+			}
+		}
+		return 0, 0, errors.New("there is no C(n) within the steps the stepper looks at")
+	}
+	if n >= len(s.configs) {
+		return 0, 0, fmt.Errorf("C(%d) is after the steps the stepper looks at", n)
+	}
+	return n, s.configs[n], nil
+}
+
+//ruleAt names the rule of the certificate that the step after the current one belongs to
+func (s *stepper) ruleAt() string {
+	if s.cert == nil {
+		return ""
+	}
+	step := s.run.steps
+	if len(s.configs) == 0 || step < s.configs[0] {
+		return "before C(0)"
+	}
+	for _, span := range s.spans {
+		if step >= span.start && step < span.end {
+			name := "Start"
+			if span.variant >= 0 {
+				name = fmt.Sprintf("variant %d", span.variant)
+			}
+			return fmt.Sprintf("C(%d) of %s, rule %d from step %d to %d", span.n, name, span.rule, span.start, span.end)
+		}
+	}
+	return ""
+}
+
+//print shows the step, the state and the tape around the head, followed by the whole tape compressed
+func (s *stepper) print(w io.Writer) {
+	run := s.run
+	fmt.Fprintf(w, "step %d, state %v, position %d", run.steps, run.state, run.pos)
+	for n, step := range s.configs {
+		if step == run.steps {
+			fmt.Fprintf(w, ", at C(%d)", n)
+		}
+	}
+	if rule := s.ruleAt(); rule != "" {
+		fmt.Fprintf(w, ", %s", rule)
+	}
+	fmt.Fprintln(w)
+	left, right := word{}, word{}
+	for pos := run.pos - stepperWindow; pos < run.pos; pos++ {
+		left = append(left, run.read(pos))
+	}
+	for pos := run.pos; pos <= run.pos+stepperWindow; pos++ {
+		right = append(right, run.read(pos))
+	}
+	leftText, _ := left.MarshalText()
+	rightText, _ := right.MarshalText()
+	fmt.Fprintf(w, "\t%s %v> %s\n", leftText, run.state, rightText)
+	tape := run.tape[run.minPos+run.offset : run.maxPos+run.offset+1]
+	fmt.Fprintf(w, "\t%s\n", formatTape(tape, run.pos-run.minPos, run.state))
+}
+
+const stepperHelp = `s [k]   step k times, 1 without k, an empty line also steps once
+g k     go to step k
+r       run to the next record
+c [n]   go to C(n) of the certificate, or to the next C(n) without n
+q       quit
+`
+
+//runStepper reads commands from input until it ends or q and prints the tm after every command
+func runStepper(tm turingMachine, cert *fullCert, input *bufio.Reader, w io.Writer) {
+	s := newStepper(tm, cert)
+	fmt.Fprint(w, stepperHelp)
+	if cert != nil && cert.Mirror {
+		fmt.Fprintln(w, "the certificate is for the mirrored tm, so the mirrored tm is shown")
+	}
+	s.print(w)
+	for {
+		line, err := input.ReadString('\n')
+		if err != nil && line == "" {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		command := "s"
+		if len(fields) > 0 {
+			command = fields[0]
+		}
+		arg := -1
+		if len(fields) > 1 {
+			arg, err = strconv.Atoi(fields[1])
+			if err != nil || arg < 0 {
+				fmt.Fprintf(w, "%s is no number of steps\n", fields[1])
+				continue
+			}
+		}
+		ok := true
+		switch command {
+		case "s":
+			if arg < 0 {
+				arg = 1
+			}
+			ok = s.goTo(s.run.steps + arg)
+		case "g":
+			if arg < 0 {
+				fmt.Fprint(w, stepperHelp)
+				continue
+			}
+			ok = s.goTo(arg)
+		case "r":
+			ok = s.nextRecord()
+		case "c":
+			n, step, err := s.config(arg)
+			if err != nil {
+				fmt.Fprintln(w, err)
+				continue
+			}
+			fmt.Fprintf(w, "C(%d) is at step %d\n", n, step)
+			ok = s.goTo(step)
+		case "q":
+			return
+		default:
+			fmt.Fprint(w, stepperHelp)
+			continue
+		}
+		if !ok {
+			fmt.Fprintln(w, "the tm halted or didn't get there")
+		}
+		s.print(w)
+	}
+}
+
+//loadStepper reads a tm in standard text format, or a file that starts with a tm or a certificate
+func loadStepper(arg string) (turingMachine, *fullCert, error) {
+	if tm := parseTM(arg); tm.numStates > 0 {
+		return tm, nil, nil
+	}
+	file, err := os.Open(arg)
+	if err != nil {
+		return turingMachine{}, nil, err
+	}
+	defer file.Close()
+	text, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return turingMachine{}, nil, err
+	}
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "{") {
+		tm := parseTM(text)
+		if tm.numStates == 0 {
+			return turingMachine{}, nil, fmt.Errorf("Unable to parse %s", text)
+		}
+		return tm, nil, nil
+	}
+	v, err := findVerifier([]byte(text))
+	if err != nil {
+		return turingMachine{}, nil, err
+	}
+	cert, ok, err := v.verify([]byte(text))
+	if err != nil {
+		return turingMachine{}, nil, err
+	}
+	//the schedule of the rules only ends for a valid certificate
+	if !ok {
+		return turingMachine{}, nil, errors.New("The certificate doesn't verify")
+	}
+	switch cert := cert.(type) {
+	case fullCert:
+		return cert.Tm, &cert, nil
+	case cyclerCert:
+		return cert.Tm, nil, nil
+	case translatedCyclerCert:
+		return cert.Tm, nil, nil
+	}
+	return turingMachine{}, nil, errors.New("Unable to step through a certificate that can't be expanded")
+}
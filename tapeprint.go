@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+//the longest word compressWord looks for repetitions of
+const maxCompressPeriod = 32
+
+//compressWord prints a word with its repetitions run-length encoded, like 0 (1000)^37 100.
+//From left to right the repetition that covers the most symbols is taken if it saves more than its brackets cost.
+func compressWord(w word) string {
+	tokens := []string{}
+	literal := word{}
+	flush := func() {
+		if len(literal) > 0 {
+			text, _ := literal.MarshalText()
+			tokens = append(tokens, string(text))
+			literal = word{}
+		}
+	}
+	for i := 0; i < len(w); {
+		bestPeriod, bestCount := 0, 0
+		for period := 1; period <= maxCompressPeriod && i+2*period <= len(w); period++ {
+			count := 1
+			for i+(count+1)*period <= len(w) && reflect.DeepEqual(w[i+count*period:i+(count+1)*period], w[i:i+period]) {
+				count++
+			}
+			if (count-1)*period > 4 && count*period > bestCount*bestPeriod {
+				bestPeriod, bestCount = period, count
+			}
+		}
+		if bestCount == 0 {
+			literal = append(literal, w[i])
+			i++
+			continue
+		}
+		flush()
+		tokens = append(tokens, repetitionString(w[i:i+bestPeriod], fmt.Sprint(bestCount)))
+		i += bestCount * bestPeriod
+	}
+	flush()
+	return strings.Join(tokens, " ")
+}
+
+//compressWordWith is compressWord that first looks for runs of the given repeaters, like those a quadruple of records
+//was split into. The words between the runs are compressed by compressWord.
+func compressWordWith(w word, repeaters []word) string {
+	if len(repeaters) == 0 {
+		return compressWord(w)
+	}
+	tokens := []string{}
+	addLiteral := func(literal word) {
+		if text := compressWord(literal); text != "" {
+			tokens = append(tokens, text)
+		}
+	}
+	start := 0
+	for i := 0; i < len(w); {
+		var best word
+		bestCount := 0
+		for _, repeater := range repeaters {
+			if len(repeater) == 0 {
+				continue
+			}
+			count := 0
+			for i+(count+1)*len(repeater) <= len(w) && reflect.DeepEqual(w[i+count*len(repeater):i+(count+1)*len(repeater)], repeater) {
+				count++
+			}
+			if count >= 2 && count*len(repeater) > bestCount*len(best) {
+				best, bestCount = repeater, count
+			}
+		}
+		if bestCount == 0 {
+			i++
+			continue
+		}
+		addLiteral(w[start:i])
+		tokens = append(tokens, repetitionString(best, fmt.Sprint(bestCount)))
+		i += bestCount * len(best)
+		start = i
+	}
+	addLiteral(w[start:])
+	return strings.Join(tokens, " ")
+}
+
+func repetitionString(w word, exponent string) string {
+	text, _ := w.MarshalText()
+	return fmt.Sprintf("(%s)^%s", text, exponent)
+}
+
+//compressed is the record like String, with its tape compressed by compressWordWith with the repeaters, if any
+func (rec record) compressed(repeaters []word) string {
+	tape := word{}
+	for cell := rec.tape.first; cell != nil; cell = cell.next {
+		tape = append(tape, cell.value.base())
+	}
+	return fmt.Sprintf("<%v %s", rec.state, compressWordWith(tape, repeaters))
+}
+
+//formatTape prints a configuration of a simulation with the head on tape[pos] as left S> right
+func formatTape(tape []baseSymbol, pos int, state tmState) string {
+	left := compressWord(tape[:pos])
+	right := compressWord(tape[pos:])
+	if left == "" {
+		return fmt.Sprintf("%v> %s", state, right)
+	}
+	return fmt.Sprintf("%s %v> %s", left, state, right)
+}
+
+//formatStart prints C(n) with the repeaters of the certificate, or with n as exponent if n is negative.
+//The walls are compressed like any other word.
+func formatStart(start initialConditions, n int) string {
+	tokens := []string{}
+	addWords := func(from int, to int) {
+		for i := from; i < to; i++ {
+			if i%2 == 0 {
+				if wall := compressWord(start.Words[i]); wall != "" {
+					tokens = append(tokens, wall)
+				}
+				continue
+			}
+			multiplier, offset := start.repetitions(i)
+			exponent := fmt.Sprint(multiplier*n + offset)
+			if n < 0 {
+				exponent = "n"
+				if multiplier != 1 {
+					exponent = fmt.Sprintf("%dn", multiplier)
+				}
+				if offset != 0 {
+					exponent = fmt.Sprintf("(%s+%d)", exponent, offset)
+				}
+			}
+			tokens = append(tokens, repetitionString(start.Words[i], exponent))
+		}
+	}
+	buffer, _ := start.Buffer.MarshalText()
+	split := start.pos()
+	if start.Dir == L {
+		split += 1
+	}
+	addWords(0, split)
+	if start.Dir == L {
+		tokens = append(tokens, fmt.Sprintf("<%v", start.State))
+	}
+	if len(buffer) > 0 {
+		tokens = append(tokens, string(buffer))
+	}
+	if start.Dir == R {
+		tokens = append(tokens, fmt.Sprintf("%v>", start.State))
+	}
+	addWords(split, len(start.Words))
+	return strings.Join(tokens, " ")
+}
+
+//how many records at each end show prints
+const showRecords = 4
+
+//showMachine prints the configuration after stepLimit steps and the last records at each end
+func showMachine(w io.Writer, tm turingMachine, stepLimit int) {
+	run := newMachineRun(tm)
+	run.run(stepLimit)
+	tape := run.tape[run.minPos+run.offset : run.maxPos+run.offset+1]
+	fmt.Fprintf(w, "%v\n\tafter %d steps: %s\n", tm, run.steps, formatTape(tape, run.pos-run.minPos, run.state))
+	records := findRecords(tm, stepLimit)
+	for _, dir := range []direction{L, R} {
+		side := "left"
+		if dir == R {
+			side = "right"
+		}
+		dirRecords := records[dir]
+		if len(dirRecords) > showRecords {
+			dirRecords = dirRecords[len(dirRecords)-showRecords:]
+		}
+		for _, rec := range dirRecords {
+			fmt.Fprintf(w, "\t%s record at step %d: %s\n", side, rec.steps, rec.compressed(nil))
+		}
+	}
+}
+
+//showCert prints C(n) of every variant of a certificate in general and for the first values of n
+func showCert(w io.Writer, cert fullCert) {
+	fmt.Fprintln(w, cert.Tm)
+	if cert.Mirror {
+		fmt.Fprintln(w, "\tthe configurations are those of the mirrored tm")
+	}
+	starts := []initialConditions{cert.Start}
+	for _, variant := range cert.Variants {
+		starts = append(starts, variant.Start)
+	}
+	for i, start := range starts {
+		name := "Start"
+		if i > 0 {
+			name = fmt.Sprintf("variant %d", i-1)
+		}
+		fmt.Fprintf(w, "\t%s C(n): %s\n", name, formatStart(start, -1))
+		for n := 0; n < 3; n++ {
+			fmt.Fprintf(w, "\t%s C(%d): %s\n", name, n, formatStart(start, n))
+		}
+	}
+}
+
+//runShow prints every tm or certificate from the input with compressed tapes
+func runShow(input *bufio.Reader, output io.Writer, stepLimit int) {
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "{") {
+			tm := parseTM(text)
+			if tm.numStates == 0 {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n", text)
+				continue
+			}
+			showMachine(output, tm, stepLimit)
+			continue
+		}
+		v, err := findVerifier([]byte(text))
+		var cert certificate
+		if err == nil {
+			cert, _, err = v.verify([]byte(text))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to parse %s\n%s\n", text, err)
+			continue
+		}
+		switch cert := cert.(type) {
+		case fullCert:
+			showCert(output, cert)
+		case cyclerCert:
+			showMachine(output, cert.Tm, cert.Steps+cert.Period)
+		case translatedCyclerCert:
+			showMachine(output, cert.Tm, cert.Steps2)
+		default:
+			fmt.Fprintf(os.Stderr, "Unable to show %s\n", text)
+		}
+	}
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//traceEntry is the outcome of one record quadruple that findBouncer examined
+type traceEntry struct {
+	side    direction //R for the records at the right end, which are checked on the mirrored tm
+	index   int
+	records   [4]record
+	err       error  //nil if the quadruple decided the tm
+	repeaters []word //the repeaters the records were split into, nil if findRepeaters failed or didn't run
+}
+
+//stage is the index of the stage that failed in stageNames, or len(stageNames) if the quadruple decided the tm
+func (entry traceEntry) stage() int {
+	var e *stageError
+	if errors.As(entry.err, &e) {
+		return e.stage
+	}
+	return len(stageNames)
+}
+
+func (entry traceEntry) String() string {
+	side := "left"
+	if entry.side == R {
+		side = "right"
+	}
+	result := "decided"
+	if entry.err != nil {
+		result = entry.err.Error()
+	}
+	return fmt.Sprintf("%s %d at steps %d %d %d %d: %s", side, entry.index,
+		entry.records[0].steps, entry.records[1].steps, entry.records[2].steps, entry.records[3].steps, result)
+}
+
+//traceBouncer goes through the record quadruples in the same order as findBouncer and stops at the first one that decides the tm
+func traceBouncer(tm turingMachine, stepLimit int, maxSpacing int) []traceEntry {
+	records := findRecords(tm, stepLimit)
+	entries := []traceEntry{}
+	for _, side := range []direction{L, R} {
+		sideTm := tm
+		if side == R {
+			sideTm = tm.mirror()
+		}
+		for i, quadruple := range recordQuadruples(records[side], maxSpacing) {
+			_, err := explainRecords(sideTm, side == R, quadruple)
+			entry := traceEntry{side: side, index: i + 1, records: quadruple, err: err}
+			if entry.stage() > stageRepeaters {
+				//split the records again like explainRecords did, to print them with their repeaters
+				colorTape1, colorTape2, bufSize := colorRecords(sideTm, quadruple)
+				words, _ := findRepeaters(colorTape1, colorTape2, bufSize)
+				for j := 1; j < len(words); j += 2 {
+					entry.repeaters = append(entry.repeaters, words[j])
+				}
+			}
+			entries = append(entries, entry)
+			if err == nil {
+				return entries
+			}
+		}
+	}
+	return entries
+}
+
+//runTrace prints every tm from the input followed by its trace, one quadruple per line
+func runTrace(input *bufio.Reader, output io.Writer, workTokens chan struct{}, stepLimit int, maxSpacing int) {
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		if text == "" {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		_ = <-workTokens
+		go func() {
+			defer func() {
+				workTokens <- struct{}{}
+				if err := recover(); err != nil {
+					fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
+				}
+			}()
+			tm := parseTM(text)
+			if tm.numStates == 0 {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n", text)
+				return
+			}
+			//print the trace of a tm at once so the traces of different tms don't mix
+			var b strings.Builder
+			fmt.Fprintln(&b, tm)
+			for _, entry := range traceBouncer(tm, stepLimit, maxSpacing) {
+				fmt.Fprintf(&b, "\t%v\n", entry)
+				//the tapes help to see why the repeaters weren't found or didn't work out
+				if entry.stage() >= stageRepeaters {
+					for _, rec := range entry.records {
+						fmt.Fprintf(&b, "\t\trecord at step %d: %s\n", rec.steps, rec.compressed(entry.repeaters))
+					}
+				}
+			}
+			io.WriteString(output, b.String())
+		}()
+	}
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+}
+
+//deepestStage is the furthest stage any quadruple got to, -1 if the tm has too few records for a quadruple
+func deepestStage(entries []traceEntry) int {
+	deepest := -1
+	for _, entry := range entries {
+		if stage := entry.stage(); stage > deepest {
+			deepest = stage
+		}
+	}
+	return deepest
+}
+
+func bucketName(stage int) string {
+	if stage < 0 {
+		return "fewRecords"
+	}
+	return stageNames[stage]
+}
+
+//runReport runs the bouncer decider on the tms from the input and sorts those it doesn't decide into buckets
+//by the deepest stage they reached with traceBouncer at stepLimit. Every bucket is written to dir/<stage>.txt
+//and the number of machines per bucket is printed at the end.
+func runReport(input *bufio.Reader, output io.Writer, workTokens chan struct{}, dir string, stepLimit int, exact bool, maxSpacing int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := make([]*os.File, len(stageNames)+1)
+	writers := make([]*bufio.Writer, len(files))
+	for i := range files {
+		file, err := os.Create(filepath.Join(dir, bucketName(i-1)+".txt"))
+		if err != nil {
+			for _, file := range files[:i] {
+				file.Close()
+			}
+			return err
+		}
+		files[i] = file
+		writers[i] = bufio.NewWriter(file)
+	}
+	var mutex sync.Mutex
+	counts := make([]int, len(files))
+	decided := 0
+
+	var readerErr error
+	for readerErr == nil {
+		var text string
+		text, readerErr = input.ReadString('\n')
+		if text == "" {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		_ = <-workTokens
+		go func() {
+			defer func() {
+				workTokens <- struct{}{}
+				if err := recover(); err != nil {
+					fmt.Fprintf(os.Stderr, "Panic at %s\n%s\n", text, err)
+				}
+			}()
+			tm := parseTM(text)
+			if tm.numStates == 0 {
+				fmt.Fprintf(os.Stderr, "Unable to parse %s\n", text)
+				return
+			}
+			if _, ok := scanTM(tm, stepLimit, exact, maxSpacing); ok {
+				mutex.Lock()
+				decided++
+				mutex.Unlock()
+				return
+			}
+			bucket := deepestStage(traceBouncer(tm, stepLimit, maxSpacing)) + 1
+			mutex.Lock()
+			defer mutex.Unlock()
+			counts[bucket]++
+			fmt.Fprintln(writers[bucket], tm)
+		}()
+	}
+	if readerErr != io.EOF {
+		fmt.Fprintln(os.Stderr, readerErr)
+	}
+	//wait for the machines that are still running
+	for i := 0; i < cap(workTokens); i++ {
+		_ = <-workTokens
+	}
+	for i := 0; i < cap(workTokens); i++ {
+		workTokens <- struct{}{}
+	}
+
+	var err error
+	for i, file := range files {
+		if flushErr := writers[i].Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	fmt.Fprintf(output, "decided: %d\n", decided)
+	for i, count := range counts {
+		fmt.Fprintf(output, "%s: %d\n", bucketName(i-1), count)
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -275,12 +276,75 @@ func (tm turingMachine) mirror() turingMachine {
 
 // A0
 // --steps-->
-// word0 buffer S> word1 word2 ... wordN
+// word0 word1^n ... buffer S> wordPos^n ... wordN || word0 ... wordPos^n <S buffer ... wordN
+//
+// with Multipliers and Offsets the i-th repeater is repeated m_i*n + o_i times instead of n times
 type initialConditions struct {
-	Steps  int
-	Words  []word
-	State  tmState
-	Buffer word
+	Steps       int
+	Words       []word
+	State       tmState
+	Buffer      word
+	Pos         int       `schema:"optional"`
+	Dir         direction `schema:"optional"`
+	Multipliers []int     `json:",omitempty"`
+	Offsets     []int     `json:",omitempty"`
+}
+
+//certificates from before the position was configurable always start at word1
+func (start initialConditions) pos() int {
+	if start.Pos == 0 {
+		return 1
+	}
+	return start.Pos
+}
+
+//how often word i is repeated in C(n) is multiplier*n + offset
+func (start initialConditions) repetitions(i int) (multiplier int, offset int) {
+	if i%2 == 0 {
+		return 0, 1
+	}
+	multiplier = 1
+	if start.Multipliers != nil {
+		multiplier = start.Multipliers[i/2]
+	}
+	if start.Offsets != nil {
+		offset = start.Offsets[i/2]
+	}
+	return
+}
+
+//the part of the tape that belongs to word i in C(n)
+func (start initialConditions) power(i int, n int) word {
+	multiplier, offset := start.repetitions(i)
+	return start.Words[i].repeat(multiplier*n + offset)
+}
+
+func (start initialConditions) mirror() initialConditions {
+	newStart := initialConditions{
+		Steps:       start.Steps,
+		Words:       make([]word, len(start.Words)),
+		State:       start.State,
+		Buffer:      start.Buffer.reverse(),
+		Pos:         len(start.Words) - 1 - start.pos(),
+		Dir:         !start.Dir,
+		Multipliers: reverseInts(start.Multipliers),
+		Offsets:     reverseInts(start.Offsets),
+	}
+	for i, w := range start.Words {
+		newStart.Words[len(start.Words)-1-i] = w.reverse()
+	}
+	return newStart
+}
+
+func reverseInts(ints []int) []int {
+	if ints == nil {
+		return nil
+	}
+	reversed := make([]int, len(ints))
+	for i, v := range ints {
+		reversed[len(ints)-1-i] = v
+	}
+	return reversed
 }
 
 //  buffer1 S1> word1 || word1 <S1 buffer1
@@ -300,18 +364,157 @@ type transitionRule struct {
 	Stub        word
 }
 
+func (rule transitionRule) mirror() transitionRule {
+	return transitionRule{
+		StartWord:   rule.StartWord.reverse(),
+		StartDir:    !rule.StartDir,
+		StartState:  rule.StartState,
+		StartBuffer: rule.StartBuffer.reverse(),
+		Steps:       rule.Steps,
+		Growing:     rule.Growing,
+		EndWord:     rule.EndWord.reverse(),
+		EndDir:      !rule.EndDir,
+		EndState:    rule.EndState,
+		EndBuffer:   rule.EndBuffer.reverse(),
+		Stub:        rule.Stub.reverse(),
+	}
+}
+
+//the version of the certificate format, increased with every change that older readers can't handle.
+//Certificates without a version are from before it was introduced and are read as version 1.
+//Version 2 added Pos and Dir, Multipliers and Offsets and Variants, which version 1 readers would ignore.
+const certVersion = 2
+
+const fullFormat = "bouncer-full"
+const shortFormat = "bouncer-short"
+
+//Start --Rules--> Variants[0].Start --Variants[0].Rules--> ... --> Start
+//
+//every arrow is a cycle from C(n) to C(n+1) of the next variant
 type fullCert struct {
-	Tm     turingMachine
-	Mirror bool
-	Start  initialConditions
-	Rules  []transitionRule
+	Format   string `schema:"optional"`
+	Version  int    `schema:"optional"`
+	Tm       turingMachine
+	Mirror   bool
+	Start    initialConditions
+	Rules    []transitionRule
+	Variants []fullVariant `json:",omitempty"`
+	//how Tm was normalized from the machine the certificate was found for
+	Mapping *tmMapping `json:",omitempty"`
+}
+
+//a different shape of the walls that the induction cycles through,
+//Start.Steps is not used
+type fullVariant struct {
+	Start initialConditions
+	Rules []transitionRule
+}
+
+//the same proof with L and R swapped, for the mirrored tm
+func (cert fullCert) mirror() fullCert {
+	newCert := fullCert{
+		Tm:     cert.Tm.mirror(),
+		Mirror: cert.Mirror,
+		Start:  cert.Start.mirror(),
+		Rules:  mirrorRules(cert.Rules),
+	}
+	for _, variant := range cert.Variants {
+		newCert.Variants = append(newCert.Variants, fullVariant{variant.Start.mirror(), mirrorRules(variant.Rules)})
+	}
+	return newCert
+}
+
+func mirrorRules(rules []transitionRule) []transitionRule {
+	newRules := make([]transitionRule, len(rules))
+	for i, rule := range rules {
+		newRules[i] = rule.mirror()
+	}
+	return newRules
+}
+
+func (cert fullCert) short() shortCert {
+	sCert := shortCert{
+		Tm:         cert.Tm,
+		Mirror:     cert.Mirror,
+		Start:      cert.Start,
+		CycleSteps: cycleSteps(cert.Rules),
+		Mapping:    cert.Mapping,
+	}
+	for _, variant := range cert.Variants {
+		sCert.Variants = append(sCert.Variants, shortVariant{variant.Start, cycleSteps(variant.Rules)})
+	}
+	return sCert
+}
+
+func cycleSteps(rules []transitionRule) int {
+	steps := 0
+	for _, rule := range rules {
+		steps += rule.Steps
+	}
+	return steps
 }
 
 type shortCert struct {
+	Format     string `schema:"optional"`
+	Version    int    `schema:"optional"`
 	Tm         turingMachine
 	Mirror     bool
 	Start      initialConditions
 	CycleSteps int
+	Variants   []shortVariant `json:",omitempty"`
+	Mapping    *tmMapping     `json:",omitempty"`
+}
+
+type shortVariant struct {
+	Start      initialConditions
+	CycleSteps int
+}
+
+//certificates are always written with the current version and their format
+func (cert fullCert) MarshalJSON() ([]byte, error) {
+	type plainCert fullCert
+	cert.Format = fullFormat
+	cert.Version = certVersion
+	return json.Marshal(plainCert(cert))
+}
+
+func (cert *fullCert) UnmarshalJSON(text []byte) error {
+	type plainCert fullCert
+	if err := json.Unmarshal(text, (*plainCert)(cert)); err != nil {
+		return err
+	}
+	if cert.Mapping != nil && !cert.Mapping.valid(cert.Tm) {
+		return errors.New("Invalid mapping for " + cert.Tm.String())
+	}
+	return checkVersion(cert.Format, cert.Version, fullFormat)
+}
+
+func (cert shortCert) MarshalJSON() ([]byte, error) {
+	type plainCert shortCert
+	cert.Format = shortFormat
+	cert.Version = certVersion
+	return json.Marshal(plainCert(cert))
+}
+
+func (cert *shortCert) UnmarshalJSON(text []byte) error {
+	type plainCert shortCert
+	if err := json.Unmarshal(text, (*plainCert)(cert)); err != nil {
+		return err
+	}
+	if cert.Mapping != nil && !cert.Mapping.valid(cert.Tm) {
+		return errors.New("Invalid mapping for " + cert.Tm.String())
+	}
+	return checkVersion(cert.Format, cert.Version, shortFormat)
+}
+
+func checkVersion(format string, version int, expected string) error {
+	if format != "" && format != expected {
+		return fmt.Errorf("Expected a %s certificate instead of %s", expected, format)
+	}
+	if version < 0 || version > certVersion {
+		return fmt.Errorf("Unknown certificate version %d, versions up to %d are supported", version, certVersion)
+	}
+	return nil
 }
 
 type word []baseSymbol
@@ -331,3 +534,19 @@ func (w *word) UnmarshalText(text []byte) error {
 	}
 	return nil
 }
+
+func (w word) reverse() word {
+	rw := make(word, len(w))
+	for i, sy := range w {
+		rw[len(w)-1-i] = sy
+	}
+	return rw
+}
+
+func (w word) repeat(n int) word {
+	rw := make(word, 0, len(w)*n)
+	for i := 0; i < n; i++ {
+		rw = append(rw, w...)
+	}
+	return rw
+}
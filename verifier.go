@@ -3,9 +3,24 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
 )
 
+//tmStep does one step of the tm at pos of tape and returns false if it halts.
+//The new position can be just outside of the tape, the callers grow it the way they need.
+func tmStep(tm turingMachine, state tmState, pos int, tape []baseSymbol) (tmState, int, bool) {
+	tr, ok := tm.transitions[headConfig{state, tape[pos]}]
+	if !ok {
+		return state, pos, false
+	}
+	tape[pos] = tr.symbol
+	if tr.direction == L {
+		return tr.state, pos - 1, true
+	}
+	return tr.state, pos + 1, true
+}
+
 func runTm(tm turingMachine, startState tmState, startPos int, startTape []baseSymbol, stepLimit int, growth map[direction]bool) (finalState tmState, finalPos int, finalTape []baseSymbol, steps int) {
 	finalTape = make([]baseSymbol, len(startTape))
 	copy(finalTape, startTape)
@@ -16,17 +31,11 @@ func runTm(tm turingMachine, startState tmState, startPos int, startTape []baseS
 	}
 	for steps = 1; steps <= stepLimit; steps++ {
 
-		tr, ok := tm.transitions[headConfig{finalState, finalTape[finalPos]}]
+		var ok bool
+		finalState, finalPos, ok = tmStep(tm, finalState, finalPos, finalTape)
 		if !ok {
 			return
 		}
-		finalTape[finalPos] = tr.symbol
-		finalState = tr.state
-		if tr.direction == L {
-			finalPos -= 1
-		} else {
-			finalPos += 1
-		}
 		switch finalPos {
 		case -1:
 			//leaving tape to the left
@@ -48,24 +57,18 @@ func runTm(tm turingMachine, startState tmState, startPos int, startTape []baseS
 	return
 }
 
-func verifyBouncer(cert fullCert, printMode int) bool {
+func verifyBouncer(cert fullCert) bool {
 	tm := cert.Tm
 	if cert.Mirror {
 		tm = tm.mirror()
 	}
-	result := checkInitialConditions(tm, cert.Start) &&
-		checkRules(tm, cert.Rules) &&
-		checkApplication(cert.Start, cert.Rules)
-
-	if result && printMode >= 0 {
-		printCert(cert, printMode)
-	}
-	return result
+	return checkInitialConditions(tm, cert.Start) &&
+		checkVariants(tm, cert)
 }
 
 func checkInitialConditions(tm turingMachine, start initialConditions) bool {
 
-	if len(start.Words) < 3 || len(start.Words)%2 != 1 {
+	if !checkShape(start) {
 		return false
 	}
 
@@ -78,12 +81,23 @@ func checkInitialConditions(tm turingMachine, start initialConditions) bool {
 		R: true,
 	}
 
+	//the buffer sits between the head and the word on the other side
+	split := start.pos()
+	if start.Dir == L {
+		split += 1
+	}
 	claimedState := start.State
-	claimedPos := len(start.Words[0]) + len(start.Buffer)
-	claimedTape := make([]baseSymbol, claimedPos)
-	copy(claimedTape, append(start.Words[0], start.Buffer...))
-	for i := 2; i < len(start.Words); i += 2 {
-		claimedTape = append(claimedTape, start.Words[i]...)
+	claimedTape := []baseSymbol{}
+	for i := 0; i < split; i++ {
+		claimedTape = append(claimedTape, start.power(i, 0)...)
+	}
+	claimedPos := len(claimedTape) - 1
+	if start.Dir == R {
+		claimedPos = len(claimedTape) + len(start.Buffer)
+	}
+	claimedTape = append(claimedTape, start.Buffer...)
+	for i := split; i < len(start.Words); i++ {
+		claimedTape = append(claimedTape, start.power(i, 0)...)
 	}
 	claimedSteps := start.Steps
 
@@ -95,19 +109,122 @@ func checkInitialConditions(tm turingMachine, start initialConditions) bool {
 		reflect.DeepEqual(claimedTape, actualTape)
 }
 
+//limits for the numbers in a certificate, so the verifier never runs or allocates without bound for a corrupted one.
+//Certificates of the decider stay far below them.
+const (
+	maxCertSteps  = 1 << 25 //steps of Start and of a rule or cycle
+	maxCertLength = 1 << 20 //symbols of C(0) and of the copies that C(n+1) adds
+)
+
+func checkShape(start initialConditions) bool {
+	if len(start.Words) < 3 || len(start.Words)%2 != 1 {
+		return false
+	}
+	if start.Steps < 0 || start.Steps > maxCertSteps {
+		return false
+	}
+	pos := start.pos()
+	if pos%2 != 1 || pos >= len(start.Words) {
+		return false
+	}
+	numRepeaters := len(start.Words) / 2
+	if (start.Multipliers != nil && len(start.Multipliers) != numRepeaters) ||
+		(start.Offsets != nil && len(start.Offsets) != numRepeaters) {
+		return false
+	}
+	length := len(start.Buffer)
+	for i, w := range start.Words {
+		multiplier, offset := start.repetitions(i)
+		if i%2 == 1 && (multiplier < 1 || offset < 0) {
+			return false
+		}
+		//each one below the limit, so the products can't overflow
+		if len(w) > maxCertLength || multiplier > maxCertLength || offset > maxCertLength {
+			return false
+		}
+		length += len(w) * (multiplier*(i%2) + offset)
+		if length > maxCertLength {
+			return false
+		}
+	}
+	return true
+}
+
+//the rules of every variant have to lead to the next variant and the last ones back to Start
+func checkVariants(tm turingMachine, cert fullCert) bool {
+	return explainVariants(tm, cert) == nil
+}
+
+//explainBouncer is verifyBouncer with the first check that failed
+func explainBouncer(cert fullCert) error {
+	tm := cert.Tm
+	if cert.Mirror {
+		tm = tm.mirror()
+	}
+	if !checkShape(cert.Start) {
+		return fmt.Errorf("the words of Start don't have the shape of C(n)")
+	}
+	if !checkInitialConditions(tm, cert.Start) {
+		return fmt.Errorf("the tm doesn't reach Start in %d steps", cert.Start.Steps)
+	}
+	return explainVariants(tm, cert)
+}
+
+func explainVariants(tm turingMachine, cert fullCert) error {
+	starts := []initialConditions{cert.Start}
+	rules := [][]transitionRule{cert.Rules}
+	for _, variant := range cert.Variants {
+		starts = append(starts, variant.Start)
+		rules = append(rules, variant.Rules)
+	}
+	//the shapes of all of them first, checkApplication expands the next one
+	for i, start := range starts {
+		if !checkShape(start) {
+			if i == 0 {
+				return fmt.Errorf("the words of Start don't have the shape of C(n)")
+			}
+			return fmt.Errorf("the words of variant %d don't have the shape of C(n)", i-1)
+		}
+	}
+	for i, start := range starts {
+		name := "Start"
+		if i > 0 {
+			name = fmt.Sprintf("variant %d", i-1)
+		}
+		if err := explainRuleList(tm, rules[i]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !checkApplication(start, rules[i], starts[(i+1)%len(starts)]) {
+			next := "Start"
+			if i+1 < len(starts) {
+				next = fmt.Sprintf("variant %d", i)
+			}
+			return fmt.Errorf("the rules of %s don't lead from C(n) to C(n+1) of %s", name, next)
+		}
+	}
+	return nil
+}
+
 func checkRules(tm turingMachine, rules []transitionRule) bool {
+	return explainRuleList(tm, rules) == nil
+}
+
+func explainRuleList(tm turingMachine, rules []transitionRule) error {
 	if len(rules) < 2 || len(rules)%2 != 0 {
-		return false
+		return fmt.Errorf("%d rules aren't an even number of at least 2", len(rules))
 	}
 	for i, rule := range rules {
+		if rule.Steps < 0 || rule.Steps > maxCertSteps {
+			return fmt.Errorf("rule %d has %d steps", i, rule.Steps)
+		}
 		if !checkRule(tm, rule) {
-			return false
+			return fmt.Errorf("rule %d doesn't match the tm", i)
 		}
 		if i%2 == 0 && !checkChainRule(rule) {
-			return false
+			return fmt.Errorf("rule %d is no chain rule", i)
 		}
 	}
-	return true
+	return nil
 }
 
 func checkRule(tm turingMachine, rule transitionRule) bool {
@@ -162,10 +279,10 @@ func checkChainRule(rule transitionRule) bool {
 		reflect.DeepEqual(rule.StartBuffer, rule.EndBuffer)
 }
 
-func checkApplication(start initialConditions, rules []transitionRule) bool {
+func checkApplication(start initialConditions, rules []transitionRule, next initialConditions) bool {
 	actualState := start.State
-	actualDir := R
-	actualPos := 1
+	actualDir := start.Dir
+	actualPos := start.pos()
 	actualBuffer := start.Buffer
 	actualWords := make([]word, len(start.Words))
 	copy(actualWords, start.Words)
@@ -189,7 +306,7 @@ func checkApplication(start initialConditions, rules []transitionRule) bool {
 	}
 	actualStub := rules[len(rules)-1].Stub
 
-	return checkInduction(actualState, actualDir, actualPos, actualBuffer, actualWords, actualStub, start)
+	return checkInduction(actualState, actualDir, actualPos, actualBuffer, actualWords, actualStub, start, next)
 }
 
 func checkRuleContext(curState tmState, curDir direction, curPos int, curBuffer word, curWords []word, rule transitionRule, lastRule bool) bool {
@@ -201,26 +318,59 @@ func checkRuleContext(curState tmState, curDir direction, curPos int, curBuffer
 		reflect.DeepEqual(rule.StartWord, curWords[curPos])
 }
 
-func checkInduction(actualState tmState, actualDir direction, actualPos int, actualBuffer word, actualWords []word, actualStub word, start initialConditions) bool {
-	if actualState != start.State ||
-		actualDir != R ||
-		actualPos != 1 ||
-		!reflect.DeepEqual(actualBuffer, start.Buffer) ||
-		!reflect.DeepEqual(actualWords[0], start.Words[0]) {
+func checkInduction(actualState tmState, actualDir direction, actualPos int, actualBuffer word, actualWords []word, actualStub word, start initialConditions, next initialConditions) bool {
+	if len(actualWords) != len(next.Words) ||
+		actualState != next.State ||
+		actualDir != next.Dir ||
+		actualPos != next.pos() ||
+		!reflect.DeepEqual(actualBuffer, next.Buffer) {
 		return false
 	}
-	actualRightWords := make([]word, len(actualWords))
-	copy(actualRightWords, actualWords)
-	actualRightWords[0] = actualStub
 
-	claimedRightWords := make([]word, len(start.Words))
-	copy(claimedRightWords, start.Words)
-	claimedRightWords[0] = word{}
+	//actualWords still have the repetitions of start, so C(n+1) of next needs
+	//growth[i] extra copies of word i to look the same
+	growth := make([]int, len(next.Words))
+	for i := 1; i < len(next.Words); i += 2 {
+		multiplier, offset := start.repetitions(i)
+		nextMultiplier, nextOffset := next.repetitions(i)
+		growth[i] = nextMultiplier + nextOffset - offset
+		if multiplier != nextMultiplier || growth[i] < 0 {
+			return false
+		}
+	}
+
+	//the stub becomes a wall of its own on the side of the head that the buffer is not on
+	split := next.pos()
+	if next.Dir == L {
+		split += 1
+	}
+	actualLeftWords := append([]word{}, actualWords[:split]...)
+	actualRightWords := append([]word{}, actualWords[split:]...)
+	claimedLeftWords := append([]word{}, next.Words[:split]...)
+	claimedRightWords := append([]word{}, next.Words[split:]...)
+	rightGrowth := growth[split:]
+	switch next.Dir {
+	case L:
+		actualLeftWords = append(actualLeftWords, actualStub)
+		claimedLeftWords = append(claimedLeftWords, word{})
+	case R:
+		actualRightWords = append([]word{actualStub}, actualRightWords...)
+		claimedRightWords = append([]word{{}}, claimedRightWords...)
+		rightGrowth = growth[split-1:]
+	}
+
+	//split the extra copies off w^(m*(n+1)+o) and add them to the wall on the side away from the head
+	for i := 1; i < len(claimedLeftWords); i += 2 {
+		claimedLeftWords[i+1] = append(claimedLeftWords[i].repeat(growth[i]), claimedLeftWords[i+1]...)
+	}
 	for i := 1; i < len(claimedRightWords); i += 2 {
-		claimedRightWords[i-1] = append(claimedRightWords[i-1], claimedRightWords[i]...)
+		claimedRightWords[i-1] = append(append(word{}, claimedRightWords[i-1]...), claimedRightWords[i].repeat(rightGrowth[i])...)
 	}
 
-	return checkWords(actualRightWords, claimedRightWords)
+	leftAlign(actualLeftWords)
+	leftAlign(claimedLeftWords)
+	return reflect.DeepEqual(actualLeftWords, claimedLeftWords) &&
+		checkWords(actualRightWords, claimedRightWords)
 }
 
 func checkWords(actualRightWords []word, claimedRightWords []word) bool {
@@ -231,60 +381,54 @@ func checkWords(actualRightWords []word, claimedRightWords []word) bool {
 
 func rightAlign(words []word) {
 	for i := len(words) - 1; i > 1; i -= 2 {
-		for len(words[i]) > 0 && words[i][0] == words[i-1][0] {
+		for len(words[i]) > 0 && len(words[i-1]) > 0 && words[i][0] == words[i-1][0] {
+			//new slices, the words can share their arrays with the rules
 			sy := words[i][0]
 			words[i] = words[i][1:]
-			words[i-1] = append(words[i-1][1:], sy)
-			words[i-2] = append(words[i-2], sy)
+			words[i-1] = append(append(word{}, words[i-1][1:]...), sy)
+			words[i-2] = append(append(word{}, words[i-2]...), sy)
+		}
+	}
+}
+
+func leftAlign(words []word) {
+	for i := 0; i < len(words)-2; i += 2 {
+		for len(words[i]) > 0 && len(words[i+1]) > 0 && words[i][len(words[i])-1] == words[i+1][len(words[i+1])-1] {
+			sy := words[i][len(words[i])-1]
+			words[i] = words[i][:len(words[i])-1]
+			words[i+1] = append(word{sy}, words[i+1][:len(words[i+1])-1]...)
+			words[i+2] = append(word{sy}, words[i+2]...)
 		}
 	}
 }
 
-func printCert(cert fullCert, printMode int) {
+func fprintCert(w io.Writer, cert fullCert, printMode int) {
 	switch printMode {
 	case 0:
-		fmt.Println(cert.Tm)
+		fmt.Fprintln(w, cert.originalTm())
 	case 1:
-		sCert := shortCert{
-			Tm:         cert.Tm,
-			Mirror:     cert.Mirror,
-			Start:      cert.Start,
-			CycleSteps: 0,
-		}
-		for _, rule := range cert.Rules {
-			sCert.CycleSteps += rule.Steps
-		}
-		b, err := json.Marshal(sCert)
+		b, err := json.Marshal(cert.short())
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println(string(b))
+		fmt.Fprintln(w, string(b))
 	case 2:
 		b, err := json.Marshal(cert)
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println(string(b))
+		fmt.Fprintln(w, string(b))
 	case 3:
-		sCert := shortCert{
-			Tm:         cert.Tm,
-			Mirror:     cert.Mirror,
-			Start:      cert.Start,
-			CycleSteps: 0,
-		}
-		for _, rule := range cert.Rules {
-			sCert.CycleSteps += rule.Steps
-		}
-		b, err := json.MarshalIndent(sCert, "", "\t")
+		b, err := json.MarshalIndent(cert.short(), "", "\t")
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println(string(b))
+		fmt.Fprintln(w, string(b))
 	case 4:
 		b, err := json.MarshalIndent(cert, "", "\t")
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println(string(b))
+		fmt.Fprintln(w, string(b))
 	}
 }